@@ -26,7 +26,7 @@ In order of priority:
 
 - No support for SWIG
 
-- No test sharding or test XML.
+- No test sharding.
 
 """
 
@@ -186,7 +186,8 @@ def _is_external(p):
   """Checks if the string starts with ../"""
   return p[0:3] == '../'
 
-def emit_go_compile_action(ctx, sources, deps, out_lib, extra_objects=[]):
+def emit_go_compile_action(ctx, sources, deps, out_lib, extra_objects=[],
+                           package_path=""):
   """Construct the command line for compiling Go code.
   Constructs a symlink tree to accommodate for workspace name.
 
@@ -198,6 +199,10 @@ def emit_go_compile_action(ctx, sources, deps, out_lib, extra_objects=[]):
     out_lib: the artifact (configured target?) that should be produced
     extra_objects: an iterable of extra object files to be added to the
       output archive file.
+    package_path: if set, the import path passed to the compiler with -p.
+      Symbols are then qualified by this path instead of by the path the
+      archive is linked under, which lets a main package be linked as a
+      library.
   """
   tree_layout = {}
   inputs = []
@@ -224,9 +229,11 @@ def emit_go_compile_action(ctx, sources, deps, out_lib, extra_objects=[]):
       "-o", ('../' * out_depth) + out_lib.path, "-pack",
       "-I", "."
   ]
+  # TODO(bazel-team): set -p for every library, not only for the ones
+  # linked into tests.
+  if package_path:
+    args += ["-p", package_path]
 
-  # Set -p to the import path of the library, ie.
-  # (ctx.label.package + "/" ctx.label.name) for now.
   cmds += [ "export GOROOT=$(pwd)/" + ctx.file.go_tool.dirname + "/..",
     ' '.join(args + [prefix + _remove_external_prefix(i.path) for i in sources])]
   extra_inputs = ctx.files.toolchain
@@ -246,8 +253,13 @@ def emit_go_compile_action(ctx, sources, deps, out_lib, extra_objects=[]):
       executable = f,
       env = go_environment_vars(ctx))
 
-def go_library_impl(ctx):
-  """Implements the go_library() rule."""
+def go_library_impl(ctx, package_path=""):
+  """Implements the go_library() rule.
+
+  Args:
+    ctx: The skylark Context.
+    package_path: passed on to emit_go_compile_action.
+  """
 
   sources = set(ctx.files.srcs)
   go_srcs = set([s for s in sources if s.basename.endswith('.go')])
@@ -284,7 +296,8 @@ def go_library_impl(ctx):
 
   out_lib = ctx.outputs.lib
   emit_go_compile_action(ctx, go_srcs, deps, out_lib,
-                         extra_objects=extra_objects)
+                         extra_objects=extra_objects,
+                         package_path=package_path)
 
   transitive_libs = set([out_lib])
   transitive_importmap = {out_lib.path: _go_importpath(ctx)}
@@ -457,12 +470,14 @@ def go_test_impl(ctx):
   It emits an action to run the test generator, and then compiles the
   test into a binary."""
 
-  lib_result = go_library_impl(ctx)
+  go_import = _go_importpath(ctx)
+
+  # The package under test is compiled under its own import path so that it
+  # can be linked into the generated main even if it is a main package.
+  lib_result = go_library_impl(ctx, package_path=go_import)
   main_go = ctx.outputs.main_go
   prefix = _go_prefix(ctx)
 
-  go_import = _go_importpath(ctx)
-
   args = (["--package", go_import, "--output", ctx.outputs.main_go.path] +
           [i.path for i in lib_result.go_sources])
 
@@ -480,13 +495,21 @@ def go_test_impl(ctx):
 
   importmap = lib_result.transitive_go_importmap + {
       ctx.outputs.main_lib.path: _go_importpath(ctx) + "_main_test"}
+
+  # For the same reason, "main.X" refers to the package under test rather than
+  # to the generated main.
+  x_defs = {}
+  for k, v in ctx.attr.x_defs.items():
+    if k.startswith("main."):
+      k = go_import + k[len("main"):]
+    x_defs[k] = v
   emit_go_link_action(
     ctx,
     importmap=importmap,
     transitive_libs=lib_result.transitive_go_library_object,
     cgo_deps=lib_result.transitive_cgo_deps,
     lib=ctx.outputs.main_lib, executable=ctx.outputs.executable,
-    x_defs=ctx.attr.x_defs)
+    x_defs=x_defs)
 
   # TODO(bazel-team): the Go tests should do a chdir to the directory
   # holding the data files, so open-source go tests continue to work
@@ -16,6 +16,12 @@
 def _go_repository_impl(ctx):
   fetch_repo = ctx.path(ctx.attr._fetch_repo)
 
+  if ctx.attr.urls:
+    _fetch_archive(ctx, fetch_repo)
+    return
+
+  if ctx.attr.strip_prefix or ctx.attr.sha256:
+    fail("strip_prefix and sha256 may only be specified with urls", "urls")
   if ctx.attr.commit and ctx.attr.tag:
     fail("cannot specify both of commit and tag", "commit")
   if ctx.attr.commit:
@@ -25,16 +31,64 @@ def _go_repository_impl(ctx):
   else:
     fail("neither commit or tag is specified", "commit")
 
-  # TODO(yugui): support submodule?
-  # c.f. https://www.bazel.io/versions/master/docs/be/workspace.html#git_repository.init_submodules
+  if ctx.attr.vcs and not ctx.attr.remote:
+    fail("if vcs is specified, remote must also be", "vcs")
+  if ctx.attr.root and not ctx.attr.vcs:
+    fail("root may only be specified with vcs", "root")
   remote = ctx.attr.remote if ctx.attr.remote else ctx.attr.importpath
-  result = ctx.execute([
-      fetch_repo,
-      '--dest', ctx.path(''),
-      '--remote', remote,
-      '--rev', rev])
+  cmds = [fetch_repo,
+          '--dest', ctx.path(''),
+          '--remote', remote,
+          '--rev', rev,
+          '--importpath', ctx.attr.importpath]
+  if ctx.attr.vcs:
+    cmds += ['--vcs', ctx.attr.vcs]
+  if ctx.attr.root:
+    cmds += ['--root', ctx.attr.root]
+  # GO_REPOSITORY_MIRROR names a directory with local copies of repositories,
+  # laid out by import path, so that fetching works without network access.
+  mirror = ctx.os.environ.get("GO_REPOSITORY_MIRROR", "")
+  if mirror:
+    cmds += ['--mirror', mirror]
+  # GO_REPOSITORY_CACHE names a directory where copies of repositories are
+  # kept, so that fetching them again only downloads what changed.
+  cache = ctx.os.environ.get("GO_REPOSITORY_CACHE", "")
+  if cache:
+    cmds += ['--cache', cache]
+  if ctx.attr.sum:
+    cmds += ['--sum', ctx.attr.sum]
+  if ctx.attr.init_submodules:
+    cmds += ['--init_submodules']
+  result = ctx.execute(cmds)
   if result.return_code:
     fail("failed to fetch %s: %s" % (remote, result.stderr))
+  _print_sum(ctx, result)
+
+
+def _fetch_archive(ctx, fetch_repo):
+  if ctx.attr.commit or ctx.attr.tag or ctx.attr.remote or ctx.attr.vcs or ctx.attr.root or ctx.attr.init_submodules:
+    fail("urls cannot be specified together with commit, tag, remote, vcs, root or init_submodules", "urls")
+  cmds = [fetch_repo, '--dest', ctx.path(''),
+          '--importpath', ctx.attr.importpath]
+  for url in ctx.attr.urls:
+    cmds += ['--archive', url]
+  if ctx.attr.strip_prefix:
+    cmds += ['--strip_prefix', ctx.attr.strip_prefix]
+  if ctx.attr.sha256:
+    cmds += ['--sha256', ctx.attr.sha256]
+  if ctx.attr.sum:
+    cmds += ['--sum', ctx.attr.sum]
+  result = ctx.execute(cmds)
+  if result.return_code:
+    fail("failed to fetch %s: %s" % (ctx.attr.urls[0], result.stderr))
+  _print_sum(ctx, result)
+
+
+def _print_sum(ctx, result):
+  # Without a sum to check, fetch_repo prints the sum of what it fetched, so
+  # that it can be copied into the rule.
+  if not ctx.attr.sum:
+    print("%s: sum = %s" % (ctx.name, result.stdout.strip()))
 
 
 def _new_go_repository_impl(ctx):
@@ -60,8 +114,15 @@ _go_repository_attrs = {
     "build_file_name": attr.string(),
     "importpath": attr.string(mandatory = True),
     "remote": attr.string(),
+    "vcs": attr.string(default = "", values = ["", "git", "hg", "svn", "bzr"]),
+    "root": attr.string(),
     "commit": attr.string(),
     "tag": attr.string(),
+    "sum": attr.string(),
+    "init_submodules": attr.bool(default = False),
+    "urls": attr.string_list(),
+    "strip_prefix": attr.string(),
+    "sha256": attr.string(),
     "build_tags": attr.string_list(),
     "_fetch_repo": attr.label(
         default = Label("@io_bazel_rules_go_repository_tools//:bin/fetch_repo"),
@@ -72,10 +133,15 @@ _go_repository_attrs = {
     ),
 }
 
+# The environment variables that change how repositories are fetched. Bazel
+# fetches a repository again when one of them changes.
+_go_repository_environ = ["GO_REPOSITORY_MIRROR", "GO_REPOSITORY_CACHE"]
+
 
 go_repository = repository_rule(
     implementation = _go_repository_impl,
     attrs = _go_repository_attrs,
+    environ = _go_repository_environ,
 )
 
 
@@ -93,6 +159,7 @@ new_go_repository = repository_rule(
         # TODO(yugui) Remove this attribute when we drop support of Bazel 0.3.2.
         "rules_go_repo_only_for_internal_use": attr.string(),
     },
+    environ = _go_repository_environ,
 )
 
 # See also #135.
@@ -110,4 +177,5 @@ def _buildifier_repository_impl(ctx):
 buildifier_repository_only_for_internal_use = repository_rule(
     implementation = _buildifier_repository_impl,
     attrs = _go_repository_attrs,
+    environ = _go_repository_environ,
 )
@@ -0,0 +1,259 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package runfiles locates the data dependencies ("runfiles") of go_binary
+// and go_test targets.
+//
+// Runfiles are named by workspace-qualified paths, such as
+// "io_bazel_rules_go/go/tools/gazelle/testdata" for a path in the main
+// repository, or "com_github_golang_glog/glog.go" for a path in an external
+// repository. They are found either in the runfiles directory that Bazel
+// creates next to the executable, or through the MANIFEST file that lists
+// them when no such directory exists.
+package runfiles
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	dirEnv       = "RUNFILES_DIR"
+	manifestEnv  = "RUNFILES_MANIFEST_FILE"
+	srcdirEnv    = "TEST_SRCDIR"
+	workspaceEnv = "TEST_WORKSPACE"
+)
+
+// executable is the absolute path of the running binary. It is computed
+// before main runs, since tests change the working directory.
+var executable, _ = filepath.Abs(os.Args[0])
+
+// Runfiles locates the runfiles of the running binary or test.
+type Runfiles struct {
+	// dir is the runfiles directory, or "" if there is none.
+	dir string
+
+	// manifestFile is the path of the MANIFEST file, and manifest maps
+	// runfile paths to the paths listed in it. Both are empty when the
+	// runfiles directory is used instead.
+	manifestFile string
+	manifest     map[string]string
+
+	// workspace is the name of the main workspace, or "" if it is unknown.
+	workspace string
+}
+
+// New locates the runfiles of the running binary or test. It uses the
+// environment variables set by Bazel if present, and otherwise looks for a
+// runfiles directory or MANIFEST file next to the executable.
+func New() (*Runfiles, error) {
+	return newRunfiles(os.Getenv, executable)
+}
+
+func newRunfiles(getenv func(string) string, exe string) (*Runfiles, error) {
+	r := &Runfiles{workspace: getenv(workspaceEnv)}
+
+	dir := getenv(dirEnv)
+	if dir == "" {
+		dir = getenv(srcdirEnv)
+	}
+	manifestFile := getenv(manifestEnv)
+	if dir == "" && manifestFile == "" && exe != "" {
+		dir = exe + ".runfiles"
+		for _, m := range []string{filepath.Join(dir, "MANIFEST"), exe + ".runfiles_manifest"} {
+			if isFile(m) {
+				manifestFile = m
+				break
+			}
+		}
+	}
+
+	if dir != "" && isDir(dir) {
+		r.dir = dir
+		return r, nil
+	}
+	if manifestFile == "" {
+		return nil, fmt.Errorf("runfiles: could not find the runfiles of %s", exe)
+	}
+	m, err := readManifest(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	r.manifestFile = manifestFile
+	r.manifest = m
+	return r, nil
+}
+
+// readManifest parses a MANIFEST file. Each line holds a runfile path, a
+// space and the path of the file it refers to.
+func readManifest(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("runfiles: %v", err)
+	}
+	defer f.Close()
+
+	m := make(map[string]string)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" {
+			continue
+		}
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			m[line] = ""
+			continue
+		}
+		m[line[:i]] = line[i+1:]
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("runfiles: reading %s: %v", path, err)
+	}
+	return m, nil
+}
+
+// Workspace returns the name of the main workspace, or "" if it is unknown.
+// It is only known for tests.
+func (r *Runfiles) Workspace() string {
+	return r.workspace
+}
+
+// Rlocation returns the path of the runfile with the given
+// workspace-qualified path, which may name a file or a directory. Absolute
+// paths are returned unchanged.
+func (r *Runfiles) Rlocation(path string) (string, error) {
+	if filepath.IsAbs(path) {
+		return path, nil
+	}
+	clean := filepath.ToSlash(filepath.Clean(path))
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("runfiles: invalid runfile path %q", path)
+	}
+
+	candidates := []string{clean}
+	if r.workspace != "" && !strings.HasPrefix(clean, r.workspace+"/") {
+		// Older versions of Bazel lay out external repositories under
+		// the main workspace.
+		candidates = append(candidates, r.workspace+"/external/"+clean)
+	}
+	for _, c := range candidates {
+		if p, ok := r.lookup(c); ok {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("runfiles: %s not found", path)
+}
+
+func (r *Runfiles) lookup(path string) (string, bool) {
+	if r.dir != "" {
+		p := filepath.Join(r.dir, filepath.FromSlash(path))
+		if _, err := os.Stat(p); err != nil {
+			return "", false
+		}
+		return p, true
+	}
+	if p, ok := r.manifest[path]; ok {
+		return p, true
+	}
+	// Directories are not listed in the manifest, but a file beneath one
+	// usually lives in a matching directory on disk.
+	prefix := path + "/"
+	for k, v := range r.manifest {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		rel := filepath.FromSlash(k[len(prefix):])
+		if strings.HasSuffix(v, string(filepath.Separator)+rel) {
+			return v[:len(v)-len(rel)-1], true
+		}
+	}
+	return "", false
+}
+
+// Path returns the path of a runfile given by a path relative to the root
+// of the main workspace.
+func (r *Runfiles) Path(rel string) (string, error) {
+	if r.workspace == "" {
+		return "", fmt.Errorf("runfiles: cannot locate %s: main workspace name is unknown", rel)
+	}
+	return r.Rlocation(r.workspace + "/" + filepath.ToSlash(rel))
+}
+
+// Env returns environment variables, in the form "key=value", that let a
+// child process find the same runfiles.
+func (r *Runfiles) Env() []string {
+	if r.dir != "" {
+		return []string{
+			dirEnv + "=" + r.dir,
+			"JAVA_RUNFILES=" + r.dir,
+		}
+	}
+	return []string{manifestEnv + "=" + r.manifestFile}
+}
+
+var (
+	defaultOnce sync.Once
+	defaultRun  *Runfiles
+	defaultErr  error
+)
+
+func defaultRunfiles() (*Runfiles, error) {
+	defaultOnce.Do(func() {
+		defaultRun, defaultErr = New()
+	})
+	return defaultRun, defaultErr
+}
+
+// Rlocation calls Rlocation on the runfiles of the running binary or test.
+func Rlocation(path string) (string, error) {
+	r, err := defaultRunfiles()
+	if err != nil {
+		return "", err
+	}
+	return r.Rlocation(path)
+}
+
+// Path calls Path on the runfiles of the running binary or test.
+func Path(rel string) (string, error) {
+	r, err := defaultRunfiles()
+	if err != nil {
+		return "", err
+	}
+	return r.Path(rel)
+}
+
+// Env calls Env on the runfiles of the running binary or test.
+func Env() ([]string, error) {
+	r, err := defaultRunfiles()
+	if err != nil {
+		return nil, err
+	}
+	return r.Env(), nil
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+func isFile(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && !fi.IsDir()
+}
@@ -0,0 +1,159 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runfiles
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files ...string) {
+	for _, f := range files {
+		path := filepath.Join(dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func envFunc(env map[string]string) func(string) string {
+	return func(key string) string { return env[key] }
+}
+
+func TestDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	exe := filepath.Join(dir, "bin", "prog")
+	runfiles := exe + ".runfiles"
+	writeFiles(t, runfiles,
+		"main_ws/pkg/data.txt",
+		"ext_repo/file.txt",
+		"main_ws/external/old_repo/file.txt")
+
+	for _, env := range []map[string]string{
+		{workspaceEnv: "main_ws"},
+		{workspaceEnv: "main_ws", srcdirEnv: runfiles},
+		{workspaceEnv: "main_ws", dirEnv: runfiles},
+	} {
+		r, err := newRunfiles(envFunc(env), exe)
+		if err != nil {
+			t.Fatalf("newRunfiles with %v: %v", env, err)
+		}
+		for path, want := range map[string]string{
+			"main_ws/pkg/data.txt":  "main_ws/pkg/data.txt",
+			"main_ws/pkg":           "main_ws/pkg",
+			"ext_repo/file.txt":     "ext_repo/file.txt",
+			"old_repo/file.txt":     "main_ws/external/old_repo/file.txt",
+			"main_ws/pkg/../pkg/./": "main_ws/pkg",
+		} {
+			got, err := r.Rlocation(path)
+			if err != nil {
+				t.Errorf("Rlocation(%q) with %v: %v", path, env, err)
+				continue
+			}
+			if want := filepath.Join(runfiles, want); got != want {
+				t.Errorf("Rlocation(%q) with %v = %q; want %q", path, env, got, want)
+			}
+		}
+		if got, err := r.Path("pkg/data.txt"); err != nil || got != filepath.Join(runfiles, "main_ws/pkg/data.txt") {
+			t.Errorf("Path(%q) = %q, %v", "pkg/data.txt", got, err)
+		}
+		if got, want := r.Env(), []string{dirEnv + "=" + runfiles, "JAVA_RUNFILES=" + runfiles}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Env() = %q; want %q", got, want)
+		}
+	}
+}
+
+func TestManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	writeFiles(t, src, "pkg/data.txt", "pkg/sub/more.txt", "ext/file.txt")
+	exe := filepath.Join(dir, "bin", "prog")
+	manifest := exe + ".runfiles_manifest"
+	content := fmt.Sprintf("main_ws/pkg/data.txt %s\nmain_ws/pkg/sub/more.txt %s\next_repo/file.txt %s\nmain_ws/empty \n",
+		filepath.Join(src, "pkg", "data.txt"),
+		filepath.Join(src, "pkg", "sub", "more.txt"),
+		filepath.Join(src, "ext", "file.txt"))
+	if err := os.MkdirAll(filepath.Dir(exe), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(manifest, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, env := range []map[string]string{
+		{workspaceEnv: "main_ws"},
+		{workspaceEnv: "main_ws", manifestEnv: manifest},
+	} {
+		r, err := newRunfiles(envFunc(env), exe)
+		if err != nil {
+			t.Fatalf("newRunfiles with %v: %v", env, err)
+		}
+		for path, want := range map[string]string{
+			"main_ws/pkg/data.txt": filepath.Join(src, "pkg", "data.txt"),
+			"main_ws/pkg/sub":      filepath.Join(src, "pkg", "sub"),
+			"ext_repo/file.txt":    filepath.Join(src, "ext", "file.txt"),
+			"main_ws/empty":        "",
+		} {
+			if got, err := r.Rlocation(path); err != nil || got != want {
+				t.Errorf("Rlocation(%q) with %v = %q, %v; want %q", path, env, got, err, want)
+			}
+		}
+		if got, want := r.Env(), []string{manifestEnv + "=" + manifest}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Env() = %q; want %q", got, want)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	exe := filepath.Join(dir, "prog")
+	if _, err := newRunfiles(envFunc(nil), exe); err == nil {
+		t.Errorf("newRunfiles succeeded without runfiles")
+	}
+
+	writeFiles(t, exe+".runfiles", "ws/file.txt")
+	r, err := newRunfiles(envFunc(nil), exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"ws/missing.txt", "../ws/file.txt", "."} {
+		if got, err := r.Rlocation(path); err == nil {
+			t.Errorf("Rlocation(%q) = %q; want error", path, got)
+		}
+	}
+	if got, err := r.Path("file.txt"); err == nil {
+		t.Errorf("Path without workspace = %q; want error", got)
+	}
+}
@@ -0,0 +1,114 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package testenv gives go_test targets access to the files and directories
+// that Bazel sets up for tests. Outside of Bazel, for example under
+// "go test", it falls back to reasonable defaults.
+//
+// Tests without a TestMain function get the premature exit file handled for
+// them by the generated test main. Tests with their own TestMain may call
+// MarkPrematureExit and ClearPrematureExit around m.Run.
+package testenv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	tmpDirEnv           = "TEST_TMPDIR"
+	undeclaredOutputEnv = "TEST_UNDECLARED_OUTPUTS_DIR"
+	warningsFileEnv     = "TEST_WARNINGS_OUTPUT_FILE"
+	prematureExitEnv    = "TEST_PREMATURE_EXIT_FILE"
+)
+
+// InBazel reports whether the test is being run by Bazel.
+func InBazel() bool {
+	return os.Getenv("TEST_SRCDIR") != ""
+}
+
+// TmpDir returns a writable directory private to the test. Outside of Bazel,
+// it is the system temporary directory.
+func TmpDir() string {
+	if dir := os.Getenv(tmpDirEnv); dir != "" {
+		return dir
+	}
+	return os.TempDir()
+}
+
+// UndeclaredOutputsDir returns a directory where the test may write files
+// that Bazel should keep after the test finishes. Outside of Bazel, a new
+// directory is created under TmpDir.
+func UndeclaredOutputsDir() (string, error) {
+	if dir := os.Getenv(undeclaredOutputEnv); dir != "" {
+		return dir, os.MkdirAll(dir, 0777)
+	}
+	return ioutil.TempDir(TmpDir(), "undeclared_outputs")
+}
+
+// Warn records a warning that Bazel shows to the user when the test
+// completes. Outside of Bazel, the warning is printed to stderr.
+func Warn(format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	path := os.Getenv(warningsFileEnv)
+	if path == "" {
+		_, err := fmt.Fprintf(os.Stderr, "warning: %s\n", msg)
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, msg); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// PrematureExitFile returns the path of the file whose presence tells Bazel
+// that the test exited before it was done, or "" outside of Bazel.
+func PrematureExitFile() string {
+	return os.Getenv(prematureExitEnv)
+}
+
+// MarkPrematureExit creates the premature exit file, so that the test is
+// considered failed if the process exits before ClearPrematureExit is
+// called. It does nothing outside of Bazel.
+func MarkPrematureExit() error {
+	path := PrematureExitFile()
+	if path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, nil, 0666)
+}
+
+// ClearPrematureExit removes the premature exit file once the test has
+// completed normally. It does nothing outside of Bazel.
+func ClearPrematureExit() error {
+	path := PrematureExitFile()
+	if path == "" {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testenv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setenv sets an environment variable for the duration of a test and
+// returns a function that restores it.
+func setenv(t *testing.T, key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestFallbacks(t *testing.T) {
+	for _, key := range []string{tmpDirEnv, undeclaredOutputEnv, warningsFileEnv, prematureExitEnv} {
+		defer setenv(t, key, "")()
+	}
+
+	if got, want := TmpDir(), os.TempDir(); got != want {
+		t.Errorf("TmpDir() = %q; want %q", got, want)
+	}
+	dir, err := UndeclaredOutputsDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		t.Errorf("UndeclaredOutputsDir() = %q, which is not a directory", dir)
+	}
+	if err := MarkPrematureExit(); err != nil {
+		t.Errorf("MarkPrematureExit: %v", err)
+	}
+	if err := ClearPrematureExit(); err != nil {
+		t.Errorf("ClearPrematureExit: %v", err)
+	}
+}
+
+func TestBazel(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "testenv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	outputs := filepath.Join(tmp, "outputs")
+	warnings := filepath.Join(tmp, "warnings")
+	premature := filepath.Join(tmp, "premature")
+	defer setenv(t, tmpDirEnv, tmp)()
+	defer setenv(t, undeclaredOutputEnv, outputs)()
+	defer setenv(t, warningsFileEnv, warnings)()
+	defer setenv(t, prematureExitEnv, premature)()
+
+	if got := TmpDir(); got != tmp {
+		t.Errorf("TmpDir() = %q; want %q", got, tmp)
+	}
+	if got, err := UndeclaredOutputsDir(); err != nil || got != outputs {
+		t.Errorf("UndeclaredOutputsDir() = %q, %v; want %q", got, err, outputs)
+	}
+
+	if err := Warn("first %d", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := Warn("second"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(warnings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Split(string(b), "\n"), []string{"first 1", "second", ""}; strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("warnings file = %q; want %q", got, want)
+	}
+
+	if err := MarkPrematureExit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(premature); err != nil {
+		t.Errorf("premature exit file not created: %v", err)
+	}
+	if err := ClearPrematureExit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(premature); !os.IsNotExist(err) {
+		t.Errorf("premature exit file not removed: %v", err)
+	}
+	if err := ClearPrematureExit(); err != nil {
+		t.Errorf("second ClearPrematureExit: %v", err)
+	}
+}
@@ -1,15 +1,49 @@
 // Command extract_package is a helper program that extracts a package name
 // from a golang source file.
+//
+// Given a single file, it prints the file's package name. Given several
+// files, a params file (@file), or the -json flag, it prints a JSON array
+// describing each file: its package name, import comment, imports, cgo usage
+// and build constraints. In that mode it fails if the non-test files do not
+// agree on the package name.
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
+	"go/ast"
+	"go/build"
 	"go/parser"
 	"go/token"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
 )
 
+// fileInfo describes a Go source file.
+type fileInfo struct {
+	Filename string `json:"filename"`
+	Package  string `json:"package"`
+
+	// ImportComment is the path in an import comment on the package clause,
+	// such as `package foo // import "example.com/foo"`.
+	ImportComment string `json:"import_comment,omitempty"`
+
+	Imports []string `json:"imports"`
+	Cgo     bool     `json:"cgo"`
+
+	// GoBuild and PlusBuild are the //go:build and +build lines in the
+	// file's header.
+	GoBuild   string   `json:"go_build,omitempty"`
+	PlusBuild []string `json:"plus_build,omitempty"`
+}
+
 func extract(fname string) (string, error) {
 	fset := token.NewFileSet()
 	f, err := parser.ParseFile(fset, fname, nil, parser.PackageClauseOnly)
@@ -19,14 +53,165 @@ func extract(fname string) (string, error) {
 	return f.Name.String(), nil
 }
 
+// extractInfo reads the package clause, imports and build constraints of the
+// file fname. It also returns the parsed constraints.
+func extractInfo(fname string) (*fileInfo, *packages.Constraints, error) {
+	src, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, nil, err
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, fname, src, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := packages.ParseConstraints(src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", fname, err)
+	}
+
+	info := &fileInfo{
+		Filename:      fname,
+		Package:       f.Name.Name,
+		ImportComment: importComment(fset, f),
+		Imports:       []string{},
+		GoBuild:       c.GoBuild,
+		PlusBuild:     c.PlusBuild,
+	}
+	for _, imp := range f.Imports {
+		path, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: invalid import path %s", fset.Position(imp.Pos()), imp.Path.Value)
+		}
+		if path == "C" {
+			info.Cgo = true
+		}
+		info.Imports = append(info.Imports, path)
+	}
+	return info, c, nil
+}
+
+// importComment returns the path in the import comment that follows the
+// package clause of f on the same line, or "" if there is none.
+func importComment(fset *token.FileSet, f *ast.File) string {
+	line := fset.Position(f.Name.End()).Line
+	for _, g := range f.Comments {
+		if g.Pos() < f.Name.End() {
+			continue
+		}
+		if fset.Position(g.Pos()).Line != line {
+			break
+		}
+		text := g.List[0].Text
+		if strings.HasPrefix(text, "//") {
+			text = text[2:]
+		} else {
+			text = strings.TrimSuffix(text[2:], "*/")
+		}
+		text = strings.TrimSpace(text)
+		if !strings.HasPrefix(text, "import ") && !strings.HasPrefix(text, "import\t") {
+			continue
+		}
+		if path, err := strconv.Unquote(strings.TrimSpace(text[len("import"):])); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// extractAll describes each of the given files. It fails if the files that
+// are not tests declare different packages. Files that are excluded in bctx
+// by their build constraints or by a _GOOS or _GOARCH suffix, such as
+// "//go:build ignore" generators next to a library, are described but do not
+// take part in the comparison.
+func extractAll(bctx build.Context, fnames []string) ([]*fileInfo, error) {
+	infos := []*fileInfo{}
+	var pkgFile *fileInfo
+	for _, fname := range fnames {
+		info, c, err := extractInfo(fname)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+		if strings.HasSuffix(fname, "_test.go") || !c.Match(bctx) {
+			continue
+		}
+		if ok, err := packages.MatchName(bctx, filepath.Base(fname)); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		if pkgFile == nil {
+			pkgFile = info
+		} else if info.Package != pkgFile.Package {
+			return nil, fmt.Errorf("found packages %s (%s) and %s (%s)", pkgFile.Package, pkgFile.Filename, info.Package, info.Filename)
+		}
+	}
+	return infos, nil
+}
+
+// expandArgs replaces each argument of the form @file by the lines of file.
+func expandArgs(args []string) ([]string, error) {
+	var expanded []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "@") {
+			expanded = append(expanded, arg)
+			continue
+		}
+		b, err := ioutil.ReadFile(arg[1:])
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if line != "" {
+				expanded = append(expanded, line)
+			}
+		}
+	}
+	return expanded, nil
+}
+
 func main() {
-	args := os.Args
-	if len(args) != 2 {
-		log.Fatal("Usage: extract_package GO_FILE")
+	jsonOut := flag.Bool("json", false, "Print a JSON description of each file instead of the package name.")
+	output := flag.String("output", "", "File to write the output to. Defaults to stdout.")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: extract_package [-json] [-output FILE] GO_FILE... | @PARAMS_FILE")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 1 && !*jsonOut && !strings.HasPrefix(flag.Arg(0), "@") {
+		name, err := extract(flag.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(name)
+		return
+	}
+
+	fnames, err := expandArgs(flag.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(fnames) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	infos, err := extractAll(build.Default, fnames)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out, err := json.MarshalIndent(infos, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	out = append(out, '\n')
+	if *output == "" {
+		_, err = os.Stdout.Write(out)
+	} else {
+		err = ioutil.WriteFile(*output, out, 0644)
 	}
-	name, err := extract(args[1])
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Println(name)
 }
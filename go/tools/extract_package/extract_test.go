@@ -1,8 +1,11 @@
 package main
 
 import (
+	"go/build"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -40,3 +43,97 @@ func TestExtract(t *testing.T) {
 		}
 	}
 }
+
+func TestExtractAll(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "extract_package")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"a.go": `//go:build linux
+// +build linux
+
+package foo // import "example.com/foo"
+
+import (
+	"fmt"
+	_ "unsafe"
+)
+`,
+		"b.go": `package foo /* import "example.com/other" */
+
+// #include <stdio.h>
+import "C"
+`,
+		"a_test.go": `package foo_test
+
+import "testing"
+`,
+		"bar.go":         `package bar`,
+		"foo_windows.go": `package bar`,
+		"gen.go": `//go:build ignore
+
+package main
+`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+	bctx := build.Default
+	bctx.GOOS = "linux"
+
+	infos, err := extractAll(bctx, []string{path("a.go"), path("b.go"), path("a_test.go")})
+	if err != nil {
+		t.Fatalf("extractAll failed with %v; want success", err)
+	}
+	want := []*fileInfo{
+		{
+			Filename:      path("a.go"),
+			Package:       "foo",
+			ImportComment: "example.com/foo",
+			Imports:       []string{"fmt", "unsafe"},
+			GoBuild:       "//go:build linux",
+			PlusBuild:     []string{"// +build linux"},
+		},
+		{
+			Filename:      path("b.go"),
+			Package:       "foo",
+			ImportComment: "example.com/other",
+			Imports:       []string{"C"},
+			Cgo:           true,
+		},
+		{
+			Filename: path("a_test.go"),
+			Package:  "foo_test",
+			Imports:  []string{"testing"},
+		},
+	}
+	if !reflect.DeepEqual(infos, want) {
+		for i := range infos {
+			t.Logf("got %+v", *infos[i])
+		}
+		t.Errorf("extractAll did not return the expected files")
+	}
+
+	if _, err := extractAll(bctx, []string{path("a.go"), path("bar.go")}); err == nil {
+		t.Errorf("extractAll with packages foo and bar succeeded; want error")
+	}
+	if _, err := extractAll(bctx, []string{path("a.go"), path("gen.go")}); err != nil {
+		t.Errorf("extractAll with an ignored package main failed with %v; want success", err)
+	}
+	if _, err := extractAll(bctx, []string{path("a.go"), path("foo_windows.go")}); err != nil {
+		t.Errorf("extractAll with package bar in a windows file failed with %v; want success", err)
+	}
+	bctx.GOOS = "darwin"
+	if _, err := extractAll(bctx, []string{path("a.go"), path("bar.go")}); err != nil {
+		t.Errorf("extractAll with package foo excluded on darwin failed with %v; want success", err)
+	}
+	bctx.GOOS = "windows"
+	if _, err := extractAll(bctx, []string{path("b.go"), path("foo_windows.go")}); err == nil {
+		t.Errorf("extractAll with packages foo and bar on windows succeeded; want error")
+	}
+}
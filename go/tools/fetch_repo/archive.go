@@ -0,0 +1,401 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// stringList is a flag that may be given several times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// fetchArchive downloads an archive from the first of urls that works,
+// verifies its SHA-256 if sha is not empty, and extracts the files under
+// stripPrefix in it into dest. It returns the URL the archive came from.
+func fetchArchive(urls []string, dest, stripPrefix, sha string) (string, error) {
+	var errs []string
+	for _, u := range urls {
+		f, err := download(u)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		if sha != "" {
+			h := sha256.New()
+			if _, err := io.Copy(h, f); err != nil {
+				return "", err
+			}
+			if got := hex.EncodeToString(h.Sum(nil)); got != strings.ToLower(sha) {
+				return "", fmt.Errorf("%s: sha256 mismatch: got %s; want %s", u, got, sha)
+			}
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		return u, extractArchive(f, archiveName(u), dest, stripPrefix)
+	}
+	return "", fmt.Errorf("could not download archive:\n%s", strings.Join(errs, "\n"))
+}
+
+// archiveName returns the file name of the archive at u, which determines its
+// format.
+func archiveName(u string) string {
+	if p, err := url.Parse(u); err == nil && p.Scheme != "" && len(p.Scheme) > 1 {
+		return path.Base(p.Path)
+	}
+	return filepath.Base(u)
+}
+
+// download copies the archive at u, which is a local path or a file://,
+// http:// or https:// URL, to a temporary file.
+func download(u string) (*os.File, error) {
+	var r io.ReadCloser
+	p, err := url.Parse(u)
+	switch {
+	// Single letter schemes are Windows drive letters.
+	case err != nil || p.Scheme == "" || len(p.Scheme) == 1:
+		r, err = os.Open(u)
+	case p.Scheme == "file":
+		r, err = os.Open(filepath.FromSlash(p.Path))
+	case p.Scheme == "http" || p.Scheme == "https":
+		var resp *http.Response
+		resp, err = http.Get(u)
+		if err == nil && resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			err = fmt.Errorf("%s: %s", u, resp.Status)
+		}
+		if err == nil {
+			r = resp.Body
+		}
+	default:
+		err = fmt.Errorf("%s: unsupported URL scheme %q", u, p.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	f, err := ioutil.TempFile("", "fetch_repo")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("%s: %v", u, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// extractArchive extracts the files under stripPrefix in the archive f into
+// dest. The format is determined by the extension of name.
+func extractArchive(f *os.File, name, dest, stripPrefix string) error {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	root, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return err
+	}
+	x := &extractor{dest: dest, root: root, stripPrefix: strings.Trim(path.Clean("/"+stripPrefix), "/")}
+	switch {
+	case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(f); err == nil {
+			err = x.tar(zr)
+		}
+	case strings.HasSuffix(name, ".tar.bz2") || strings.HasSuffix(name, ".tbz2"):
+		err = x.tar(bzip2.NewReader(f))
+	case strings.HasSuffix(name, ".zip"):
+		var fi os.FileInfo
+		if fi, err = f.Stat(); err == nil {
+			err = x.zip(f, fi.Size())
+		}
+	default:
+		return fmt.Errorf("%s: unsupported archive format; want .tar.gz, .tar.bz2 or .zip", name)
+	}
+	if err == nil {
+		err = x.checkLinks()
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	if !x.found {
+		return fmt.Errorf("%s: no files under strip_prefix %q", name, stripPrefix)
+	}
+	return nil
+}
+
+type extractor struct {
+	dest, stripPrefix string
+	// root is dest with symbolic links resolved.
+	root string
+
+	// found is set once a file under stripPrefix is extracted.
+	found bool
+	// links are the symbolic links that were extracted.
+	links []link
+}
+
+type link struct {
+	name, target string
+}
+
+// target returns the path in dest of the archive entry name, or "" if the
+// entry is not under the prefix. Entries that would escape dest are errors.
+func (x *extractor) target(name string) (string, error) {
+	name = strings.TrimPrefix(filepath.ToSlash(name), "./")
+	if path.IsAbs(name) || filepath.IsAbs(name) || escapes(name) {
+		return "", fmt.Errorf("entry %q is outside the archive root", name)
+	}
+	rel := path.Clean(name)
+	if x.stripPrefix != "" {
+		if rel == x.stripPrefix {
+			return "", nil
+		}
+		if !strings.HasPrefix(rel, x.stripPrefix+"/") {
+			return "", nil
+		}
+		rel = rel[len(x.stripPrefix)+1:]
+	}
+	if rel == "." {
+		return "", nil
+	}
+	x.found = true
+	return filepath.Join(x.dest, filepath.FromSlash(rel)), nil
+}
+
+// escapes reports whether the slash-separated relative path p refers to a
+// location outside of its root.
+func escapes(p string) bool {
+	p = path.Clean(p)
+	return p == ".." || strings.HasPrefix(p, "../")
+}
+
+func (x *extractor) tar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target, err := x.target(h.Name)
+		if err != nil {
+			return err
+		}
+		if target == "" {
+			continue
+		}
+		if err := x.prepare(h.Name, target); err != nil {
+			return err
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeFile(target, tr, os.FileMode(h.Mode))
+		case tar.TypeSymlink:
+			err = x.symlink(h.Name, h.Linkname, target)
+		case tar.TypeXGlobalHeader:
+		default:
+			err = fmt.Errorf("entry %q has unsupported type %q", h.Name, h.Typeflag)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (x *extractor) zip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		target, err := x.target(f.Name)
+		if err != nil {
+			return err
+		}
+		if target == "" {
+			continue
+		}
+		if err := x.prepare(f.Name, target); err != nil {
+			return err
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			err = os.MkdirAll(target, 0755)
+		case mode&os.ModeSymlink != 0:
+			var link []byte
+			if link, err = readZipFile(f); err == nil {
+				err = x.symlink(f.Name, string(link), target)
+			}
+		default:
+			var rc io.ReadCloser
+			if rc, err = f.Open(); err == nil {
+				err = writeFile(target, rc, mode)
+				rc.Close()
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// prepare creates the parent directory of target, the path of the archive
+// entry name, after checking that it stays within dest once the symbolic
+// links extracted so far are resolved. Links are only checked lexically when
+// they are created, so a chain of them could otherwise lead outside dest. An
+// existing link at target is removed, so that the entry replaces it rather
+// than being written through it.
+func (x *extractor) prepare(name, target string) error {
+	dir := filepath.Dir(target)
+	existing := dir
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return fmt.Errorf("entry %q: %v", name, err)
+	}
+	if rel, err := filepath.Rel(x.root, resolved); err != nil || escapes(filepath.ToSlash(rel)) {
+		return fmt.Errorf("entry %q is outside the archive root through a symbolic link", name)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if fi, err := os.Lstat(target); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return os.Remove(target)
+	}
+	return nil
+}
+
+// symlink creates a symbolic link at target to linkname, which must stay
+// within dest.
+func (x *extractor) symlink(name, linkname, target string) error {
+	rel, err := filepath.Rel(x.dest, filepath.Join(filepath.Dir(target), filepath.FromSlash(linkname)))
+	if err != nil || filepath.IsAbs(linkname) || path.IsAbs(linkname) || escapes(filepath.ToSlash(rel)) {
+		return fmt.Errorf("entry %q links outside the archive root: %s", name, linkname)
+	}
+	if err := os.Symlink(linkname, target); err != nil {
+		return err
+	}
+	x.links = append(x.links, link{name, target})
+	return nil
+}
+
+// checkLinks checks that each extracted symbolic link still leads to a
+// location within dest now that all links are in place, since a link
+// created later may change where an earlier one points.
+func (x *extractor) checkLinks() error {
+	for _, l := range x.links {
+		rel, err := filepath.Rel(x.dest, l.target)
+		if err != nil {
+			return err
+		}
+		if !resolvesWithin(x.root, rel) {
+			return fmt.Errorf("entry %q links outside the archive root through a symbolic link", l.name)
+		}
+	}
+	return nil
+}
+
+// resolvesWithin reports whether the relative path rel, resolved within the
+// directory root with symbolic links followed one component at a time,
+// never leaves root. Components that do not exist are resolved lexically.
+func resolvesWithin(root, rel string) bool {
+	cur := root
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for hops := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			cur = filepath.Dir(cur)
+			if r, err := filepath.Rel(root, cur); err != nil || escapes(filepath.ToSlash(r)) {
+				return false
+			}
+			continue
+		}
+		next := filepath.Join(cur, part)
+		fi, err := os.Lstat(next)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			cur = next
+			continue
+		}
+		if hops++; hops > 255 {
+			return false
+		}
+		dest, err := os.Readlink(next)
+		if err != nil || filepath.IsAbs(dest) {
+			return false
+		}
+		parts = append(strings.Split(filepath.ToSlash(dest), "/"), parts...)
+	}
+	return true
+}
+
+func writeFile(target string, r io.Reader, mode os.FileMode) error {
+	perm := os.FileMode(0644)
+	if mode&0111 != 0 {
+		perm = 0755
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
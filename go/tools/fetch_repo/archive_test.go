@@ -0,0 +1,157 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type archiveEntry struct {
+	name, content, link string
+}
+
+func tarGz(t *testing.T, entries []archiveEntry) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.content)), Typeflag: tar.TypeReg}
+		if e.link != "" {
+			h.Typeflag = tar.TypeSymlink
+			h.Linkname = e.link
+			h.Size = 0
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipFile(t *testing.T, entries []archiveEntry) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFetchArchive(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	entries := []archiveEntry{
+		{name: "repo-1.0/a.go", content: "package a"},
+		{name: "repo-1.0/sub/b.go", content: "package b"},
+		{name: "repo-1.0/link.go", link: "sub/b.go"},
+		{name: "other/c.go", content: "ignored"},
+	}
+	for _, c := range []struct {
+		name string
+		data []byte
+	}{
+		{"repo.tar.gz", tarGz(t, entries)},
+		{"repo.zip", zipFile(t, entries[:2])},
+	} {
+		archive := filepath.Join(dir, c.name)
+		if err := ioutil.WriteFile(archive, c.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(c.data)
+		sha := hex.EncodeToString(sum[:])
+
+		for _, u := range []string{archive, "file://" + filepath.ToSlash(archive)} {
+			dest := filepath.Join(dir, "out", c.name)
+			os.RemoveAll(dest)
+			if _, err := fetchArchive([]string{filepath.Join(dir, "missing.zip"), u}, dest, "repo-1.0/", sha); err != nil {
+				t.Errorf("fetchArchive(%q) failed with %v; want success", u, err)
+				continue
+			}
+			for name, want := range map[string]string{"a.go": "package a", "sub/b.go": "package b"} {
+				if got, err := ioutil.ReadFile(filepath.Join(dest, name)); err != nil || string(got) != want {
+					t.Errorf("fetchArchive(%q): %s = %q, %v; want %q", u, name, got, err, want)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(dest, "other")); !os.IsNotExist(err) {
+				t.Errorf("fetchArchive(%q) extracted a file outside strip_prefix", u)
+			}
+		}
+
+		if _, err := fetchArchive([]string{archive}, filepath.Join(dir, "bad"), "", "0000"); err == nil {
+			t.Errorf("fetchArchive(%q) with a wrong sha256 succeeded; want error", c.name)
+		}
+		if _, err := fetchArchive([]string{archive}, filepath.Join(dir, "bad"), "missing", ""); err == nil {
+			t.Errorf("fetchArchive(%q) with a missing strip_prefix succeeded; want error", c.name)
+		}
+	}
+}
+
+func TestFetchArchiveEscapes(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, entries := range [][]archiveEntry{
+		{{name: "../evil.go", content: "package evil"}},
+		{{name: "ok/../../evil.go", content: "package evil"}},
+		{{name: "/abs/evil.go", content: "package evil"}},
+		{{name: "link", link: "../outside"}},
+		{{name: "link", link: "/etc/passwd"}},
+		{
+			{name: "l2", link: "."},
+			{name: "l1", link: "l2/.."},
+			{name: "l1/evil.go", content: "package evil"},
+		},
+		{
+			{name: "l2", link: "."},
+			{name: "l3", link: "l2/../evil.go"},
+		},
+		{
+			{name: "l1", link: "l2/.."},
+			{name: "l2", link: "."},
+		},
+	} {
+		archive := filepath.Join(dir, "evil.tar.gz")
+		if err := ioutil.WriteFile(archive, tarGz(t, entries), 0644); err != nil {
+			t.Fatal(err)
+		}
+		dest := filepath.Join(dir, "dest", "repo")
+		if _, err := fetchArchive([]string{archive}, dest, "", ""); err == nil {
+			t.Errorf("case %d: fetchArchive(%v) succeeded; want error", i, entries)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "dest", "evil.go")); err == nil {
+			t.Errorf("case %d: fetchArchive(%v) wrote outside the destination", i, entries)
+		}
+	}
+}
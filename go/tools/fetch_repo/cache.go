@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// cacheRepo makes sure that the cache directory holds an up-to-date copy of
+// the repository r and returns its path. Git repositories are kept as bare
+// mirrors and Mercurial repositories as clones without a working directory.
+// Copies are keyed by the remote URL and updated incrementally. It returns
+// "" for version control systems that are not cached.
+//
+// The returned unlock function must be called once the copy has been
+// checked out, since concurrent fetches may update it.
+func cacheRepo(cache string, r *vcs.RepoRoot) (dir string, unlock func(), err error) {
+	var create, update []string
+	switch r.VCS.Cmd {
+	case "git":
+		create = []string{"git", "clone", "--mirror", "-q", r.Repo}
+		update = []string{"git", "remote", "update", "--prune"}
+	case "hg":
+		create = []string{"hg", "clone", "-U", "-q", r.Repo}
+		update = []string{"hg", "pull", "-q"}
+	default:
+		return "", nil, nil
+	}
+
+	dir = filepath.Join(cache, r.VCS.Cmd, cacheKey(r.Repo))
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", nil, err
+	}
+	unlock, err = lockFile(dir + ".lock")
+	if err != nil {
+		return "", nil, err
+	}
+
+	if _, err := os.Stat(dir); err == nil {
+		err = runIn(dir, update)
+	} else if os.IsNotExist(err) {
+		// Clone into a temporary directory, so that an interrupted clone
+		// is not mistaken for a complete one.
+		tmp := dir + ".tmp"
+		os.RemoveAll(tmp)
+		if err = runIn(filepath.Dir(dir), append(create, tmp)); err == nil {
+			err = os.Rename(tmp, dir)
+		}
+	}
+	if err != nil {
+		unlock()
+		return "", nil, err
+	}
+	return dir, unlock, nil
+}
+
+// cacheKey returns the name of the cached copy of the repository at url.
+func cacheKey(url string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(url)))
+}
+
+// setRemote points the checkout in dir, which was created from a cached copy,
+// back to the original remote URL.
+func setRemote(v *vcs.Cmd, dir, url string) error {
+	switch v.Cmd {
+	case "git":
+		return runIn(dir, []string{"git", "remote", "set-url", "origin", url})
+	case "hg":
+		hgrc := filepath.Join(dir, ".hg", "hgrc")
+		return writeFile(hgrc, strings.NewReader("[paths]\ndefault = "+url+"\n"), 0644)
+	}
+	return nil
+}
+
+func runIn(dir string, args []string) error {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return nil
+}
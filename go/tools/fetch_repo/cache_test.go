@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestCheckoutCache(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	origin := filepath.Join(dir, "origin")
+	first := newGitRepo(t, origin, map[string]string{"a.go": "package a"})
+	remote := "file://" + filepath.ToSlash(origin)
+	r := &vcs.RepoRoot{VCS: vcs.ByCmd("git"), Repo: remote, Root: "example.com/a"}
+
+	oldCache := *cache
+	*cache = filepath.Join(dir, "cache")
+	defer func() { *cache = oldCache }()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = checkout(r, filepath.Join(dir, "first", strconv.Itoa(i)), first)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("checkout %d failed with %v; want success", i, err)
+		}
+	}
+	mirror := filepath.Join(*cache, "git", cacheKey(remote))
+	if _, err := os.Stat(filepath.Join(mirror, "HEAD")); err != nil {
+		t.Errorf("no bare mirror in the cache: %v", err)
+	}
+
+	// A commit made after the cache was populated must be fetched.
+	if err := ioutil.WriteFile(filepath.Join(origin, "b.go"), []byte("package a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, origin, "add", "b.go")
+	git(t, origin, "commit", "-q", "-m", "second")
+	second := git(t, origin, "rev-parse", "HEAD")
+
+	dest := filepath.Join(dir, "second")
+	if err := checkout(r, dest, second); err != nil {
+		t.Fatalf("checkout after update failed with %v; want success", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "b.go")); err != nil {
+		t.Errorf("b.go was not checked out: %v", err)
+	}
+	if got := git(t, dest, "config", "remote.origin.url"); got != remote {
+		t.Errorf("origin of the checkout = %q; want %q", got, remote)
+	}
+}
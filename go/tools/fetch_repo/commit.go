@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// resolveCommit returns the revision checked out in dir, which identifies
+// it exactly, unlike a tag or branch name.
+func resolveCommit(v *vcs.Cmd, dir string) (string, error) {
+	var args []string
+	switch v.Cmd {
+	case "git":
+		args = []string{"git", "rev-parse", "HEAD"}
+	case "hg":
+		args = []string{"hg", "log", "-r", ".", "--template", "{node}"}
+	case "bzr":
+		args = []string{"bzr", "version-info", "--custom", "--template={revision_id}"}
+	case "svn":
+		args = []string{"svnversion", "-c", "."}
+	default:
+		return "", fmt.Errorf("cannot resolve revisions of %s repositories", v.Name)
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", strings.Join(args, " "), err)
+	}
+	commit := strings.TrimSpace(string(out))
+	if v.Cmd == "svn" {
+		// svnversion -c prints a range of revisions, such as 4123:4168.
+		if i := strings.LastIndex(commit, ":"); i >= 0 {
+			commit = commit[i+1:]
+		}
+	}
+	return commit, nil
+}
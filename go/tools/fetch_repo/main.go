@@ -8,31 +8,253 @@
 //
 // These differences help us to manage external Go repositories in the manner of
 // Bazel.
+//
+// To work without network access, the repository can be given explicitly with
+// -vcs and a -remote URL, which skips import path discovery, and a -mirror
+// directory can be given, which is searched for the repository before the
+// network is consulted.
+//
+// The import path does not need to be the root of a repository. For a path
+// inside a repository, the whole repository is checked out, and only the
+// directory of the path is laid out in the destination.
+//
+// With -archive, fetch_repo downloads and extracts a .tar.gz, .tar.bz2 or
+// .zip file instead of checking out a repository.
+//
+// With -sum, fetch_repo verifies the checked out files against a tree sum,
+// which excludes version control metadata. Without it, the sum is printed so
+// that it can be pinned.
+//
+// After fetching, fetch_repo records the import path, version control system,
+// remote, requested revision, resolved commit and tree sum in a metadata file
+// in the destination (see package metadata).
 package main
 
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/bazelbuild/rules_go/go/tools/fetch_repo/metadata"
 	"golang.org/x/tools/go/vcs"
 )
 
 var (
-	remote = flag.String("remote", "", "Go importpath to the repository fetch")
-	rev    = flag.String("rev", "", "target revision")
-	dest   = flag.String("dest", "", "destination directory")
+	remote     = flag.String("remote", "", "Go importpath to the repository fetch, or its URL if -vcs is given")
+	importpath = flag.String("importpath", "", "Go importpath of the repository. Defaults to -remote if -vcs is not given.")
+	vcsName    = flag.String("vcs", "", "version control system of -remote: git, hg, svn or bzr. Skips import path discovery.")
+	rootPath   = flag.String("root", "", "Go importpath of the root of the repository given with -vcs, if -importpath is a directory inside it")
+	mirror     = flag.String("mirror", "", "directory with local copies of repositories, laid out by importpath, that is searched before the network")
+	rev        = flag.String("rev", "", "target revision")
+	dest       = flag.String("dest", "", "destination directory")
+	sum        = flag.String("sum", "", "expected sum of the checked out tree; printed if not given")
+	cache      = flag.String("cache", "", "directory where copies of git and hg repositories are kept and shared between fetches")
+	submodules = flag.Bool("init_submodules", false, "recursively initialize and update git submodules")
+
+	archives    stringList
+	stripPrefix = flag.String("strip_prefix", "", "directory in the archive to extract")
+	sha256Sum   = flag.String("sha256", "", "expected SHA-256 of the archive")
 )
 
-func run() error {
+func init() {
+	flag.Var(&archives, "archive", "path or URL of an archive to fetch instead of a repository; may be repeated to give fallbacks")
+}
+
+// repoRoot determines where to fetch the repository from. It looks in the
+// mirror first, then uses the remote URL if a version control system is
+// given, and only then resolves the import path over the network.
+//
+// It also returns the slash-separated directory of the import path within
+// the repository, which is "" for the root of the repository.
+func repoRoot() (*vcs.RepoRoot, string, error) {
+	// Without -vcs, -remote is the import path to fetch; -importpath only
+	// names the result and may differ, as for a fork.
+	path := *importpath
+	if *vcsName == "" {
+		path = *remote
+	}
+	if *mirror != "" && path != "" {
+		r, err := mirrorRepoRoot(*mirror, path)
+		if err != nil {
+			return nil, "", err
+		}
+		if r != nil {
+			return r, subdir(r.Root, path), nil
+		}
+	}
+
+	if *vcsName != "" {
+		v := vcs.ByCmd(*vcsName)
+		if v == nil {
+			return nil, "", fmt.Errorf("unknown version control system: %s", *vcsName)
+		}
+		if *remote == "" {
+			return nil, "", fmt.Errorf("-remote must be given with -vcs")
+		}
+		root := *rootPath
+		if root == "" {
+			root = path
+		}
+		if path != root && !strings.HasPrefix(path, root+"/") {
+			return nil, "", fmt.Errorf("-importpath %s is not in the repository whose root is %s", path, root)
+		}
+		return &vcs.RepoRoot{VCS: v, Repo: *remote, Root: root}, subdir(root, path), nil
+	}
+	if *rootPath != "" {
+		return nil, "", fmt.Errorf("-root must be given with -vcs")
+	}
+
 	r, err := vcs.RepoRootForImportPath(*remote, true)
+	if err != nil {
+		return nil, "", err
+	}
+	return r, subdir(r.Root, *remote), nil
+}
+
+// subdir returns the directory of importpath within the repository whose
+// root import path is root.
+func subdir(root, importpath string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(importpath, root), "/")
+}
+
+func run() error {
+	if len(archives) > 0 {
+		if *submodules {
+			return fmt.Errorf("-init_submodules cannot be used with -archive")
+		}
+		if err := os.MkdirAll(*dest, 0755); err != nil {
+			return err
+		}
+		url, err := fetchArchive(archives, *dest, *stripPrefix, *sha256Sum)
+		if err != nil {
+			return err
+		}
+		s, err := checkSum(*dest, *sum)
+		if err != nil {
+			return err
+		}
+		return metadata.Write(*dest, &metadata.Metadata{
+			Importpath: *importpath,
+			VCS:        "archive",
+			Remote:     url,
+			Sum:        s,
+		})
+	}
+
+	r, sub, err := repoRoot()
+	if err != nil {
+		return err
+	}
+	root := *dest
+	if sub != "" {
+		// Check out the whole repository next to the destination, so that
+		// the subtree can be moved into place.
+		tmp, err := ioutil.TempDir(filepath.Dir(*dest), "fetch_repo")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmp)
+		root = filepath.Join(tmp, "root")
+	}
+	if err := checkout(r, root, *rev); err != nil {
+		return err
+	}
+	if *submodules {
+		if err := updateSubmodules(r.VCS, root); err != nil {
+			return err
+		}
+	}
+	commit, err := resolveCommit(r.VCS, root)
 	if err != nil {
 		return err
 	}
-	if *remote != r.Root {
-		return fmt.Errorf("not a root of a repository: %s", *remote)
+	if sub != "" {
+		if err := moveContents(filepath.Join(root, filepath.FromSlash(sub)), *dest); err != nil {
+			return fmt.Errorf("%s in repository %s: %v", sub, r.Root, err)
+		}
+	}
+	s, err := checkSum(*dest, *sum)
+	if err != nil {
+		return err
+	}
+	path := *importpath
+	if path == "" && *vcsName == "" {
+		path = *remote
+	}
+	return metadata.Write(*dest, &metadata.Metadata{
+		Importpath: path,
+		VCS:        r.VCS.Cmd,
+		Remote:     r.Repo,
+		Rev:        *rev,
+		Commit:     commit,
+		Sum:        s,
+	})
+}
+
+// moveContents moves the files and directories in src into dest.
+func moveContents(src, dest string) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", src)
+	}
+	infos, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if err := os.Rename(filepath.Join(src, info.Name()), filepath.Join(dest, info.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkout creates a checkout of r at rev in dest, going through the cache
+// if there is one.
+func checkout(r *vcs.RepoRoot, dest, rev string) error {
+	// Local repositories, such as those in a mirror, are not worth caching.
+	if *cache == "" || filepath.IsAbs(r.Repo) {
+		return r.VCS.CreateAtRev(dest, r.Repo, rev)
+	}
+	dir, unlock, err := cacheRepo(*cache, r)
+	if err != nil {
+		return err
+	}
+	if dir == "" {
+		return r.VCS.CreateAtRev(dest, r.Repo, rev)
+	}
+	defer unlock()
+	if err := r.VCS.CreateAtRev(dest, dir, rev); err != nil {
+		return err
+	}
+	return setRemote(r.VCS, dest, r.Repo)
+}
+
+// checkSum verifies that the tree sum of dir is want, or prints it if want
+// is empty. It returns the sum.
+func checkSum(dir, want string) (string, error) {
+	got, err := treeSum(dir)
+	if err != nil {
+		return "", err
+	}
+	if want == "" {
+		fmt.Println(got)
+		return got, nil
+	}
+	if got != want {
+		return "", fmt.Errorf("%s: sum mismatch: got %s; want %s", dir, got, want)
 	}
-	return r.VCS.CreateAtRev(*dest, r.Repo, *rev)
+	return got, nil
 }
 
 func main() {
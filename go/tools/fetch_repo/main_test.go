@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/fetch_repo/metadata"
+)
+
+// setFlag sets a string flag for the duration of a test and returns a
+// function that restores it.
+func setFlag(p *string, value string) func() {
+	old := *p
+	*p = value
+	return func() { *p = old }
+}
+
+func TestRunSubdir(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "subdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mirrorDir := filepath.Join(dir, "mirror")
+	commit := newGitRepo(t, filepath.Join(mirrorDir, "example.com/repo"), map[string]string{
+		"root.go":         "package repo",
+		"go/sub/a.go":     "package sub",
+		"go/sub/pkg/b.go": "package pkg",
+		"other/c.go":      "package other",
+	})
+
+	external := filepath.Join(dir, "external")
+	if err := os.MkdirAll(external, 0755); err != nil {
+		t.Fatal(err)
+	}
+	destDir := filepath.Join(external, "com_example_repo_go_sub")
+	defer setFlag(mirror, mirrorDir)()
+	defer setFlag(remote, "example.com/repo/go/sub")()
+	// go_repository always passes the importpath, which is looked up in the
+	// mirror only with -vcs.
+	defer setFlag(importpath, "example.org/sub")()
+	defer setFlag(dest, destDir)()
+	defer setFlag(rev, commit)()
+	if err := run(); err != nil {
+		t.Fatalf("run failed with %v; want success", err)
+	}
+
+	for _, name := range []string{"a.go", "pkg/b.go"} {
+		if _, err := os.Stat(filepath.Join(destDir, name)); err != nil {
+			t.Errorf("%s was not laid out: %v", name, err)
+		}
+	}
+	for _, name := range []string{"root.go", "other", "go", ".git"} {
+		if _, err := os.Stat(filepath.Join(destDir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s is in the destination; want only the subtree", name)
+		}
+	}
+	if infos, err := ioutil.ReadDir(external); err != nil || len(infos) != 1 {
+		t.Errorf("external contains %d files, %v; want only the destination", len(infos), err)
+	}
+
+	m, err := metadata.Read(destDir)
+	if err != nil {
+		t.Fatalf("metadata.Read failed with %v; want success", err)
+	}
+	want := metadata.Metadata{
+		Importpath: "example.org/sub",
+		VCS:        "git",
+		Remote:     filepath.Join(mirrorDir, "example.com/repo"),
+		Rev:        commit,
+		Commit:     commit,
+	}
+	if got, err := treeSum(destDir); err != nil || m.Sum != got {
+		t.Errorf("metadata sum = %q; want %q, %v", m.Sum, got, err)
+	}
+	m.Sum = ""
+	if *m != want {
+		t.Errorf("metadata = %+v; want %+v", *m, want)
+	}
+
+	*remote = "example.com/repo/missing"
+	*dest = filepath.Join(external, "missing")
+	if err := run(); err == nil {
+		t.Errorf("run for a missing directory succeeded; want error")
+	}
+}
+
+func TestRepoRootVCS(t *testing.T) {
+	defer setFlag(vcsName, "git")()
+	defer setFlag(remote, "https://example.com/repo.git")()
+	for _, c := range []struct {
+		importpath, root  string
+		wantRoot, wantSub string
+		wantErr           bool
+	}{
+		{importpath: "example.com/repo", wantRoot: "example.com/repo"},
+		{importpath: "example.com/repo/go/sub", root: "example.com/repo", wantRoot: "example.com/repo", wantSub: "go/sub"},
+		{importpath: "example.com/repo", root: "example.com/repo", wantRoot: "example.com/repo"},
+		{importpath: "example.com/repository", root: "example.com/repo", wantErr: true},
+	} {
+		restoreImportpath := setFlag(importpath, c.importpath)
+		restoreRoot := setFlag(rootPath, c.root)
+		r, sub, err := repoRoot()
+		restoreImportpath()
+		restoreRoot()
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("repoRoot for %s in %s succeeded; want error", c.importpath, c.root)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("repoRoot for %s in %s failed with %v; want success", c.importpath, c.root, err)
+			continue
+		}
+		if r.Root != c.wantRoot || r.Repo != *remote || sub != c.wantSub {
+			t.Errorf("repoRoot for %s in %s = %s, %s, %q; want %s, %s, %q", c.importpath, c.root, r.Root, r.Repo, sub, c.wantRoot, *remote, c.wantSub)
+		}
+	}
+
+	*vcsName = ""
+	defer setFlag(rootPath, "example.com/repo")()
+	if _, _, err := repoRoot(); err == nil {
+		t.Errorf("repoRoot with -root but not -vcs succeeded; want error")
+	}
+}
@@ -0,0 +1,75 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metadata reads and writes the file in which fetch_repo records
+// what it fetched, so that fetches can be reproduced.
+package metadata
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FileName is the name of the metadata file in the root of a fetched
+// repository.
+const FileName = ".fetch_repo.json"
+
+// Metadata describes a fetched repository.
+type Metadata struct {
+	// Importpath is the Go import path of the fetched directory.
+	Importpath string `json:"importpath,omitempty"`
+
+	// VCS is the version control system the repository was checked out
+	// with, or "archive" for repositories extracted from an archive.
+	VCS string `json:"vcs"`
+
+	// Remote is the URL the repository or archive was fetched from.
+	Remote string `json:"remote"`
+
+	// Rev is the revision that was requested, such as a tag or branch.
+	Rev string `json:"rev,omitempty"`
+
+	// Commit is the revision that Rev resolved to.
+	Commit string `json:"commit,omitempty"`
+
+	// Sum is the tree sum of the fetched files.
+	Sum string `json:"sum"`
+}
+
+// Write writes m to the metadata file in dir.
+func Write(dir string, m *Metadata) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	return ioutil.WriteFile(filepath.Join(dir, FileName), b, 0644)
+}
+
+// Read reads the metadata file in dir. The error satisfies os.IsNotExist if
+// dir has no metadata file.
+func Read(dir string) (*Metadata, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, FileName))
+	if err != nil {
+		return nil, err
+	}
+	m := &Metadata{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, &os.PathError{Op: "parse", Path: filepath.Join(dir, FileName), Err: err}
+	}
+	return m, nil
+}
@@ -0,0 +1,62 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadWrite(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := Read(dir); !os.IsNotExist(err) {
+		t.Errorf("Read of a directory without metadata = %v; want a not exist error", err)
+	}
+
+	want := &Metadata{
+		Importpath: "github.com/golang/glog",
+		VCS:        "git",
+		Remote:     "https://github.com/golang/glog",
+		Rev:        "v1.0.0",
+		Commit:     "23def4e6c14b4da8ac2ed8007337bc5eb5007998",
+		Sum:        "sha256:0123",
+	}
+	if err := Write(dir, want); err != nil {
+		t.Fatalf("Write failed with %v; want success", err)
+	}
+	got, err := Read(dir)
+	if err != nil {
+		t.Fatalf("Read failed with %v; want success", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Read = %+v; want %+v", got, want)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, FileName), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(dir); err == nil || os.IsNotExist(err) {
+		t.Errorf("Read of a corrupt file = %v; want a parse error", err)
+	}
+}
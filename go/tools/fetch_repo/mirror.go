@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// mirrorRepoRoot looks for a local copy of the repository with the given
+// import path under the mirror directory, which holds repositories at the
+// paths of their root import paths. Clones, bare git repositories and
+// Subversion repositories are recognized. It returns nil if the mirror has no
+// copy of the repository.
+func mirrorRepoRoot(mirror, importpath string) (*vcs.RepoRoot, error) {
+	root, err := filepath.Abs(mirror)
+	if err != nil {
+		return nil, err
+	}
+	for p := filepath.Clean(filepath.FromSlash(importpath)); p != "." && p != string(filepath.Separator); p = filepath.Dir(p) {
+		dir := filepath.Join(root, p)
+		fi, err := os.Stat(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			return nil, fmt.Errorf("%s in mirror is not a directory", dir)
+		}
+		v, repo := detectVCS(dir)
+		if v == nil {
+			continue
+		}
+		return &vcs.RepoRoot{VCS: v, Repo: repo, Root: filepath.ToSlash(p)}, nil
+	}
+	return nil, nil
+}
+
+// detectVCS returns the version control system of the repository in dir and
+// the URL to check it out from, or nil if dir does not hold a repository.
+func detectVCS(dir string) (*vcs.Cmd, string) {
+	for _, m := range []struct {
+		cmd  string
+		file string
+	}{
+		{"git", ".git"},
+		{"hg", ".hg"},
+		{"bzr", ".bzr"},
+		// Bare git repositories.
+		{"git", "objects"},
+		// Subversion repositories, which cannot be checked out by path.
+		{"svn", "db"},
+	} {
+		if !exists(filepath.Join(dir, m.file)) {
+			continue
+		}
+		if m.cmd == "svn" {
+			if !exists(filepath.Join(dir, "format")) {
+				continue
+			}
+			u := url.URL{Scheme: "file", Path: filepath.ToSlash(dir)}
+			return vcs.ByCmd(m.cmd), u.String()
+		}
+		if m.file == "objects" && !exists(filepath.Join(dir, "HEAD")) {
+			continue
+		}
+		return vcs.ByCmd(m.cmd), dir
+	}
+	return nil, ""
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMirrorRepoRoot(t *testing.T) {
+	mirror, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(mirror)
+	for _, f := range []string{
+		"github.com/a/clone/.git/HEAD",
+		"github.com/a/bare/HEAD",
+		"github.com/a/bare/objects/pack/x",
+		"bitbucket.org/b/hg/.hg/store",
+		"example.com/svn/format",
+		"example.com/svn/db/uuid",
+		"example.com/notrepo/README",
+	} {
+		path := filepath.Join(mirror, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []struct {
+		importpath, vcs, repo, root string
+	}{
+		{"github.com/a/clone", "git", filepath.Join(mirror, "github.com/a/clone"), "github.com/a/clone"},
+		{"github.com/a/bare", "git", filepath.Join(mirror, "github.com/a/bare"), "github.com/a/bare"},
+		{"github.com/a/bare/sub/pkg", "git", filepath.Join(mirror, "github.com/a/bare"), "github.com/a/bare"},
+		{"bitbucket.org/b/hg", "hg", filepath.Join(mirror, "bitbucket.org/b/hg"), "bitbucket.org/b/hg"},
+		{"example.com/svn", "svn", "file://" + filepath.ToSlash(filepath.Join(mirror, "example.com/svn")), "example.com/svn"},
+	} {
+		r, err := mirrorRepoRoot(mirror, c.importpath)
+		if err != nil {
+			t.Errorf("mirrorRepoRoot(%q) failed with %v; want success", c.importpath, err)
+			continue
+		}
+		if r == nil {
+			t.Errorf("mirrorRepoRoot(%q) = nil; want a repository", c.importpath)
+			continue
+		}
+		if r.VCS.Cmd != c.vcs || r.Repo != c.repo || r.Root != c.root {
+			t.Errorf("mirrorRepoRoot(%q) = {%s %q %q}; want {%s %q %q}", c.importpath, r.VCS.Cmd, r.Repo, r.Root, c.vcs, c.repo, c.root)
+		}
+	}
+
+	for _, importpath := range []string{"example.com/notrepo", "github.com/missing/repo"} {
+		if r, err := mirrorRepoRoot(mirror, importpath); err != nil || r != nil {
+			t.Errorf("mirrorRepoRoot(%q) = %v, %v; want nil, nil", importpath, r, err)
+		}
+	}
+}
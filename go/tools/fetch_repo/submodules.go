@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// updateSubmodules initializes the submodules of the repository checked out
+// in dir, recursively, at the revisions recorded in the checked out commit.
+func updateSubmodules(v *vcs.Cmd, dir string) error {
+	if v.Cmd != "git" {
+		return fmt.Errorf("submodules are only supported for git repositories, not %s", v.Name)
+	}
+	cmd := exec.Command("git", "submodule", "update", "--init", "--recursive")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git submodule update --init --recursive: %v\n%s", err, out)
+	}
+	return nil
+}
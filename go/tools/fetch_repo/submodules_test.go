@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// git runs git in dir and returns its trimmed output.
+func git(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// newGitRepo creates a git repository in dir with the given files committed.
+func newGitRepo(t *testing.T, dir string, files map[string]string) string {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git(t, dir, "init", "-q")
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git(t, dir, "add", "-A")
+	git(t, dir, "commit", "-q", "-m", "initial")
+	return git(t, dir, "rev-parse", "HEAD")
+}
+
+func TestUpdateSubmodules(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	// Recent versions of git refuse to clone local submodules by default.
+	old, ok := os.LookupEnv("GIT_CONFIG_PARAMETERS")
+	os.Setenv("GIT_CONFIG_PARAMETERS", "'protocol.file.allow=always'")
+	defer func() {
+		if ok {
+			os.Setenv("GIT_CONFIG_PARAMETERS", old)
+		} else {
+			os.Unsetenv("GIT_CONFIG_PARAMETERS")
+		}
+	}()
+
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "submodules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sub := filepath.Join(dir, "sub")
+	newGitRepo(t, sub, map[string]string{"sub.c": "int x;"})
+	top := filepath.Join(dir, "top")
+	newGitRepo(t, top, map[string]string{"main.go": "package main"})
+	git(t, top, "submodule", "-q", "add", sub, "third_party/sub")
+	git(t, top, "commit", "-q", "-m", "add submodule")
+	rev := git(t, top, "rev-parse", "HEAD")
+
+	// Move the submodule ahead, which must not affect the pinned checkout.
+	if err := ioutil.WriteFile(filepath.Join(sub, "sub.c"), []byte("int y;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, sub, "commit", "-q", "-a", "-m", "change")
+
+	dest := filepath.Join(dir, "dest")
+	v := vcs.ByCmd("git")
+	if err := v.CreateAtRev(dest, top, rev); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateSubmodules(v, dest); err != nil {
+		t.Fatalf("updateSubmodules failed with %v; want success", err)
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(dest, "third_party/sub/sub.c")); err != nil || string(got) != "int x;" {
+		t.Errorf("third_party/sub/sub.c = %q, %v; want %q", got, err, "int x;")
+	}
+
+	if err := updateSubmodules(vcs.ByCmd("hg"), dest); err == nil {
+		t.Errorf("updateSubmodules for hg succeeded; want error")
+	}
+}
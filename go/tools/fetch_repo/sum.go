@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/bazelbuild/rules_go/go/tools/fetch_repo/metadata"
+)
+
+// vcsDirs are the directories of version control metadata, which are
+// excluded from tree sums. Git submodules have a .git file instead. The
+// metadata file written by fetch_repo is excluded as well.
+var vcsDirs = map[string]bool{
+	".bzr": true,
+	".git": true,
+	".hg":  true,
+	".svn": true,
+}
+
+// treeSum computes a hash of the files under dir that does not depend on
+// the version control system they were checked out with. Files are visited
+// in order of their slash-separated paths relative to dir. For each file, a
+// line holding its kind ("f" for regular files, "x" for executable files,
+// "l" for symbolic links), the SHA-256 of its content or link target, and
+// its path is hashed.
+func treeSum(dir string) (string, error) {
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && vcsDirs[info.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Name() == ".git" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == metadata.FileName {
+			return nil
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(paths)
+
+	sum := sha256.New()
+	for _, p := range paths {
+		kind, h, err := hashFile(filepath.Join(dir, filepath.FromSlash(p)))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(sum, "%s %x %s\n", kind, h, p)
+	}
+	return fmt.Sprintf("sha256:%x", sum.Sum(nil)), nil
+}
+
+func hashFile(path string) (kind string, h []byte, err error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", nil, err
+	}
+	s := sha256.New()
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", nil, err
+		}
+		io.WriteString(s, filepath.ToSlash(target))
+		return "l", s.Sum(nil), nil
+	case !info.Mode().IsRegular():
+		return "", nil, fmt.Errorf("%s: not a regular file", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(s, f); err != nil {
+		return "", nil, err
+	}
+	kind = "f"
+	if info.Mode()&0111 != 0 {
+		kind = "x"
+	}
+	return kind, s.Sum(nil), nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTree(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestTreeSum(t *testing.T) {
+	files := map[string]string{
+		"a.go":       "package a",
+		"sub/b.go":   "package b",
+		"sub/c.txt":  "c",
+		"README.md":  "readme",
+		"sub/.keep":  "",
+		"sub/d/e.go": "package e",
+	}
+	base := writeTree(t, files)
+	defer os.RemoveAll(base)
+	want, err := treeSum(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withVCS := map[string]string{
+		".git/HEAD":        "ref: refs/heads/master",
+		".hg/store/data":   "x",
+		"sub/.svn/entries": "12",
+	}
+	for k, v := range files {
+		withVCS[k] = v
+	}
+	dir := writeTree(t, withVCS)
+	defer os.RemoveAll(dir)
+	if got, err := treeSum(dir); err != nil || got != want {
+		t.Errorf("treeSum with VCS metadata = %q, %v; want %q", got, err, want)
+	}
+
+	if err := os.Chmod(filepath.Join(dir, "a.go"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := treeSum(dir); err != nil || got == want {
+		t.Errorf("treeSum after chmod = %q, %v; want a different sum", got, err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(base, "sub/c.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := treeSum(base); err != nil || got == want {
+		t.Errorf("treeSum after edit = %q, %v; want a different sum", got, err)
+	}
+	if _, err := checkSum(dir, want); err == nil {
+		t.Errorf("checkSum(%q, %q) succeeded; want mismatch", dir, want)
+	}
+}
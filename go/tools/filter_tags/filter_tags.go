@@ -16,51 +16,265 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
 	"log"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
 )
 
+// fileResult describes whether a file passed the filter, and why not.
+type fileResult struct {
+	Filename string `json:"filename"`
+	Keep     bool   `json:"keep"`
+
+	// Reason is the kind of constraint that excluded the file: "filename"
+	// for its name or suffix, "build" for a //go:build or +build line, or
+	// "cgo" for an import of "C" when cgo is disabled. It is empty for kept
+	// files.
+	Reason string `json:"reason,omitempty"`
+
+	// Constraint is the part of the file that excluded it, such as the
+	// "_windows" suffix or the "//go:build ignore" line.
+	Constraint string `json:"constraint,omitempty"`
+
+	// Warning is set when the file's //go:build and +build lines disagree.
+	// The //go:build line decides whether the file is kept.
+	Warning string `json:"warning,omitempty"`
+}
+
 // Returns an array of strings containing only the filenames that should build
 // according to the Context given.
 func filterFilenames(bctx build.Context, inputs []string) ([]string, error) {
+	results, err := explainFilenames(bctx, inputs)
+	if err != nil {
+		return nil, err
+	}
 	outputs := []string{}
+	for _, r := range results {
+		if r.Keep {
+			outputs = append(outputs, r.Filename)
+		}
+	}
+	return outputs, nil
+}
 
+// explainFilenames reports, for each input, whether it should build
+// according to the Context given and which constraint excluded it if not.
+func explainFilenames(bctx build.Context, inputs []string) ([]fileResult, error) {
+	var results []fileResult
 	for _, filename := range inputs {
-		fullPath, err := filepath.Abs(filename)
+		r, err := explainFile(bctx, filename)
 		if err != nil {
 			return nil, err
 		}
-		dir, base := filepath.Split(fullPath)
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+func explainFile(bctx build.Context, filename string) (fileResult, error) {
+	r := fileResult{Filename: filename}
+	fullPath, err := filepath.Abs(filename)
+	if err != nil {
+		return r, err
+	}
+	dir, base := filepath.Split(fullPath)
+
+	// Check the name on its own first, by matching it against a file
+	// without any constraints.
+	if ok, err := packages.MatchName(bctx, base); err != nil {
+		return r, err
+	} else if !ok {
+		r.Reason = "filename"
+		r.Constraint = nameConstraint(bctx, base)
+		return r, nil
+	}
+
+	c, err := packages.ReadConstraints(filepath.Join(dir, base))
+	if err != nil {
+		return r, err
+	}
+	if !c.Agree(bctx) {
+		r.Warning = "//go:build and +build lines disagree"
+	}
+	if !c.Match(bctx) {
+		r.Reason = "build"
+		r.Constraint = c.GoBuild
+		if r.Constraint == "" {
+			r.Constraint = c.FailingPlusBuild(bctx)
+		}
+		return r, nil
+	}
+
+	// MatchFile evaluates the "cgo" tag but ignores CgoEnabled
+	// otherwise, so files that import "C" need to be checked here.
+	if !bctx.CgoEnabled && strings.HasSuffix(base, ".go") {
+		usesCgo, err := importsC(fullPath)
+		if err != nil {
+			return r, err
+		}
+		if usesCgo {
+			r.Reason = "cgo"
+			r.Constraint = `import "C"`
+			return r, nil
+		}
+	}
+
+	r.Keep = true
+	return r, nil
+}
+
+// nameConstraint returns the part of the file name base that excludes it.
+func nameConstraint(bctx build.Context, base string) string {
+	if strings.HasPrefix(base, "_") || strings.HasPrefix(base, ".") {
+		return base[:1]
+	}
+	ext := filepath.Ext(base)
+	name := strings.TrimSuffix(strings.TrimSuffix(base, ext), "_test")
+	parts := strings.Split(name, "_")
+	excludes := func(suffix string) bool {
+		ok, err := packages.MatchName(bctx, "x"+suffix+ext)
+		return err == nil && !ok
+	}
+	if n := len(parts); n > 2 && excludes("_"+parts[n-2]) {
+		// Both parts of a _GOOS_GOARCH suffix are needed to tell
+		// that it is one.
+		return "_" + parts[n-2] + "_" + parts[n-1]
+	}
+	if n := len(parts); n > 1 && excludes("_"+parts[n-1]) {
+		return "_" + parts[n-1]
+	}
+	return ext
+}
+
+// importsC reports whether the Go file at path imports "C".
+func importsC(path string) (bool, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.ImportsOnly)
+	if err != nil {
+		return false, err
+	}
+	for _, imp := range f.Imports {
+		if p, err := strconv.Unquote(imp.Path.Value); err == nil && p == "C" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
 
-		matches, err := bctx.MatchFile(dir, base)
+// newContext returns a build context for the given target platform. Release
+// tags are those of the Go distribution this tool was built with, which is
+// also the one used to compile the filtered files.
+func newContext(goos, goarch string, cgo bool, tags []string) build.Context {
+	bctx := build.Default
+	bctx.GOOS = goos
+	bctx.GOARCH = goarch
+	bctx.CgoEnabled = cgo
+	bctx.BuildTags = tags
+	return bctx
+}
+
+// expandArgs replaces each argument of the form @file by the lines of file.
+func expandArgs(args []string) ([]string, error) {
+	var expanded []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "@") {
+			expanded = append(expanded, arg)
+			continue
+		}
+		b, err := ioutil.ReadFile(arg[1:])
 		if err != nil {
 			return nil, err
 		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if line != "" {
+				expanded = append(expanded, line)
+			}
+		}
+	}
+	return expanded, nil
+}
 
-		if matches {
-			outputs = append(outputs, filename)
+// formatResults renders results in the given format: "names" for the kept
+// file names separated by spaces, "params" for one kept file name per line,
+// or "json" for every result.
+func formatResults(results []fileResult, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case "names", "params":
+		sep := " "
+		if format == "params" {
+			sep = "\n"
+		}
+		var kept []string
+		for _, r := range results {
+			if r.Keep {
+				kept = append(kept, r.Filename)
+			}
+		}
+		buf.WriteString(strings.Join(kept, sep))
+		buf.WriteString("\n")
+	case "json":
+		if results == nil {
+			results = []fileResult{}
 		}
+		b, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+		buf.WriteString("\n")
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
 	}
-	return outputs, nil
+	return buf.Bytes(), nil
 }
 
 func main() {
 	cgo := flag.Bool("cgo", false, "Sets whether cgo-using files are allowed to pass the filter.")
+	goos := flag.String("goos", build.Default.GOOS, "Target operating system.")
+	goarch := flag.String("goarch", build.Default.GOARCH, "Target architecture.")
 	tags := flag.String("tags", "", "Only pass through files that match these tags.")
+	format := flag.String("format", "names", "Output format: names, params or json.")
+	output := flag.String("output", "", "File to write the output to. Defaults to stdout.")
 	flag.Parse()
 
-	bctx := build.Default
-	bctx.BuildTags = strings.Split(*tags, ",")
-	bctx.CgoEnabled = *cgo // Worth setting? build.MatchFile ignores this.
+	bctx := newContext(*goos, *goarch, *cgo, strings.Split(*tags, ","))
 
-	outputs, err := filterFilenames(bctx, flag.Args())
+	inputs, err := expandArgs(flag.Args())
+	if err != nil {
+		log.Fatalf("build_tags error: %v\n", err)
+	}
+	results, err := explainFilenames(bctx, inputs)
+	if err != nil {
+		log.Fatalf("build_tags error: %v\n", err)
+	}
+	for _, r := range results {
+		if r.Warning != "" {
+			log.Printf("warning: %s: %s", r.Filename, r.Warning)
+		}
+	}
+	out, err := formatResults(results, *format)
 	if err != nil {
 		log.Fatalf("build_tags error: %v\n", err)
 	}
 
-	fmt.Println(strings.Join(outputs, " "))
+	if *output == "" {
+		_, err = os.Stdout.Write(out)
+	} else {
+		err = ioutil.WriteFile(*output, out, 0644)
+	}
+	if err != nil {
+		log.Fatalf("build_tags error: %v\n", err)
+	}
 }
@@ -21,6 +21,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"testing"
 )
 
@@ -132,3 +133,131 @@ func TestTags(t *testing.T) {
 		t.Error("Output contains an unexpected file: ignore.go")
 	}
 }
+
+var testFileCGOTAG = `
+// This file is not intended to actually build.
+
+// +build cgo
+
+package cgotag
+`
+
+var testFileRELEASE = `
+// This file is not intended to actually build.
+
+// +build go1.1,!go1.1000
+
+package release
+`
+
+func TestPlatform(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "goruletest")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	files := map[string]string{
+		"cgo.go":            testFileCGO,
+		"cgotag.go":         testFileCGOTAG,
+		"release.go":        testFileRELEASE,
+		"foo_linux.go":      "package foo",
+		"foo_darwin_arm.go": "package foo",
+		"foo_arm.go":        "package foo",
+		"foo_linux.s":       "",
+	}
+	var inputs []string
+	for k, v := range files {
+		p := filepath.Join(tempdir, k)
+		if err := ioutil.WriteFile(p, []byte(v), 0644); err != nil {
+			t.Fatalf("WriteFile(%s): %v", p, err)
+		}
+		inputs = append(inputs, p)
+	}
+	sort.Strings(inputs)
+
+	for _, tc := range []struct {
+		goos, goarch string
+		cgo          bool
+		want         []string
+	}{
+		{"linux", "amd64", false, []string{"foo_linux.go", "foo_linux.s", "release.go"}},
+		{"linux", "amd64", true, []string{"cgo.go", "cgotag.go", "foo_linux.go", "foo_linux.s", "release.go"}},
+		{"darwin", "arm", false, []string{"foo_arm.go", "foo_darwin_arm.go", "release.go"}},
+		{"windows", "386", false, []string{"release.go"}},
+	} {
+		outputs, err := filterFilenames(newContext(tc.goos, tc.goarch, tc.cgo, nil), inputs)
+		if err != nil {
+			t.Errorf("filterFilenames(%s/%s): %v", tc.goos, tc.goarch, err)
+			continue
+		}
+		got := []string{}
+		for _, o := range outputs {
+			got = append(got, filepath.Base(o))
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("filterFilenames(%s/%s, cgo=%v) = %v; want %v", tc.goos, tc.goarch, tc.cgo, got, tc.want)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "goruletest")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	files := map[string]string{
+		"cgo.go":                testFileCGO,
+		"ignore.go":             testFileIGNORE,
+		"tags.go":               testFileTAGS,
+		"foo_windows.go":        "package foo",
+		"foo_plan9_arm_test.go": "package foo",
+		"_hidden.go":            "package foo",
+		"readme.txt":            "",
+		"with space.go":         "package foo",
+		"gobuild.go":            "//go:build darwin\n\npackage foo",
+		"disagree.go":           "//go:build linux\n// +build darwin\n\npackage foo",
+	}
+	var inputs []string
+	for k, v := range files {
+		p := filepath.Join(tempdir, k)
+		if err := ioutil.WriteFile(p, []byte(v), 0644); err != nil {
+			t.Fatalf("WriteFile(%s): %v", p, err)
+		}
+		inputs = append(inputs, p)
+	}
+	sort.Strings(inputs)
+
+	results, err := explainFilenames(newContext("linux", "amd64", false, nil), inputs)
+	if err != nil {
+		t.Fatalf("explainFilenames: %v", err)
+	}
+	want := []fileResult{
+		{Filename: "_hidden.go", Reason: "filename", Constraint: "_"},
+		{Filename: "cgo.go", Reason: "cgo", Constraint: `import "C"`},
+		{Filename: "disagree.go", Keep: true, Warning: "//go:build and +build lines disagree"},
+		{Filename: "foo_plan9_arm_test.go", Reason: "filename", Constraint: "_plan9_arm"},
+		{Filename: "foo_windows.go", Reason: "filename", Constraint: "_windows"},
+		{Filename: "gobuild.go", Reason: "build", Constraint: "//go:build darwin"},
+		{Filename: "ignore.go", Reason: "build", Constraint: "//+build ignore"},
+		{Filename: "readme.txt", Reason: "filename", Constraint: ".txt"},
+		{Filename: "tags.go", Reason: "build", Constraint: "//+build arm,darwin linux,mips"},
+		{Filename: "with space.go", Keep: true},
+	}
+	for i := range results {
+		results[i].Filename = filepath.Base(results[i].Filename)
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("explainFilenames:\ngot  %+v\nwant %+v", results, want)
+	}
+
+	out, err := formatResults(results, "params")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(out), "disagree.go\nwith space.go\n"; got != want {
+		t.Errorf("params output = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,310 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Constraints holds the build constraints in the header of a source file.
+type Constraints struct {
+	// GoBuild is the //go:build line, or "" if there is none.
+	GoBuild string
+
+	// PlusBuild holds the +build lines that apply to the file.
+	PlusBuild []string
+
+	goBuild   expr
+	plusBuild []expr
+}
+
+// ReadConstraints reads the build constraints of the file at path.
+func ReadConstraints(path string) (*Constraints, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := parseConstraints(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// ParseConstraints parses the build constraints in the header of a source
+// file, that is, in the comments that precede the package clause. Like the
+// go command, it only honors +build lines that are followed by a blank line.
+func ParseConstraints(content []byte) (*Constraints, error) {
+	return parseConstraints(bytes.NewReader(content))
+}
+
+func parseConstraints(r io.Reader) (*Constraints, error) {
+	c := &Constraints{}
+	var plusBuild []string
+	br := bufio.NewReader(r)
+	inBlock := false
+Header:
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line == "" && err == io.EOF {
+			break
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case inBlock:
+			if i := strings.Index(line, "*/"); i >= 0 {
+				inBlock = false
+				if strings.TrimSpace(line[i+2:]) != "" {
+					break Header
+				}
+			}
+		case line == "":
+			// A blank line ends a block of +build lines.
+			c.PlusBuild = append(c.PlusBuild, plusBuild...)
+			plusBuild = nil
+		case strings.HasPrefix(line, "/*"):
+			inBlock = !strings.Contains(line[2:], "*/")
+		case !strings.HasPrefix(line, "//"):
+			break Header
+		case isGoBuild(line):
+			if c.GoBuild != "" {
+				return nil, fmt.Errorf("multiple //go:build comments")
+			}
+			x, err := parseGoBuild(line)
+			if err != nil {
+				return nil, err
+			}
+			c.GoBuild = line
+			c.goBuild = x
+		case isPlusBuild(line):
+			plusBuild = append(plusBuild, line)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	for _, line := range c.PlusBuild {
+		x, err := parsePlusBuild(line)
+		if err != nil {
+			return nil, err
+		}
+		c.plusBuild = append(c.plusBuild, x)
+	}
+	return c, nil
+}
+
+// Match reports whether a file with these constraints is built in bctx.
+// As in Go 1.17 and later, a //go:build line takes precedence over the
+// file's +build lines.
+func (c *Constraints) Match(bctx build.Context) bool {
+	if c.goBuild != nil {
+		return c.goBuild.eval(tagMatcher(bctx))
+	}
+	return c.matchPlusBuild(bctx)
+}
+
+func (c *Constraints) matchPlusBuild(bctx build.Context) bool {
+	return c.FailingPlusBuild(bctx) == ""
+}
+
+// FailingPlusBuild returns the first +build line that is not satisfied in
+// bctx, or "" if there is none.
+func (c *Constraints) FailingPlusBuild(bctx build.Context) string {
+	ok := tagMatcher(bctx)
+	for i, x := range c.plusBuild {
+		if !x.eval(ok) {
+			return c.PlusBuild[i]
+		}
+	}
+	return ""
+}
+
+// Agree reports whether the //go:build and +build lines give the same result
+// in bctx. It is true if the file does not have both kinds of lines.
+func (c *Constraints) Agree(bctx build.Context) bool {
+	if c.goBuild == nil || len(c.plusBuild) == 0 {
+		return true
+	}
+	return c.goBuild.eval(tagMatcher(bctx)) == c.matchPlusBuild(bctx)
+}
+
+// unixOS is the set of GOOS values matched by the "unix" build tag.
+var unixOS = map[string]bool{
+	"aix":       true,
+	"android":   true,
+	"darwin":    true,
+	"dragonfly": true,
+	"freebsd":   true,
+	"hurd":      true,
+	"illumos":   true,
+	"ios":       true,
+	"linux":     true,
+	"netbsd":    true,
+	"openbsd":   true,
+	"solaris":   true,
+}
+
+// MatchTag reports whether the build tag is satisfied in bctx, following the
+// rules of the go command.
+func MatchTag(bctx build.Context, tag string) bool {
+	switch {
+	case tag == "cgo":
+		return bctx.CgoEnabled
+	case tag == bctx.GOOS || tag == bctx.GOARCH || tag == bctx.Compiler:
+		return true
+	case tag == "linux" && bctx.GOOS == "android",
+		tag == "solaris" && bctx.GOOS == "illumos",
+		tag == "darwin" && bctx.GOOS == "ios",
+		tag == "unix" && unixOS[bctx.GOOS]:
+		return true
+	}
+	for _, tags := range [][]string{bctx.BuildTags, bctx.ReleaseTags} {
+		for _, t := range tags {
+			if t == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func tagMatcher(bctx build.Context) func(string) bool {
+	return func(tag string) bool {
+		return MatchTag(bctx, tag)
+	}
+}
+
+// MatchName reports whether a file with the given base name would be built
+// in bctx, ignoring its contents. This checks the _GOOS and _GOARCH suffixes,
+// the extension, and names that the go command always ignores.
+func MatchName(bctx build.Context, name string) (bool, error) {
+	content := ""
+	if strings.HasSuffix(name, ".go") {
+		content = "package p\n"
+	}
+	bctx.OpenFile = func(string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(content)), nil
+	}
+	return bctx.MatchFile("", name)
+}
+
+// MatchFile is like bctx.MatchFile, but evaluates build constraints with
+// ReadConstraints instead of relying on the version of go/build this
+// program was built with. It also returns the constraints it read, which
+// are nil if the file was excluded by its name.
+func MatchFile(bctx build.Context, dir, name string) (bool, *Constraints, error) {
+	if ok, err := MatchName(bctx, name); err != nil || !ok {
+		return false, nil, err
+	}
+	c, err := ReadConstraints(filepath.Join(dir, name))
+	if err != nil {
+		return false, nil, err
+	}
+	return c.Match(bctx), c, nil
+}
+
+// knownOS and knownArch are the GOOS and GOARCH values that MatchAnyFile
+// tries.
+var (
+	knownOS = []string{
+		"aix", "android", "darwin", "dragonfly", "freebsd", "hurd", "illumos",
+		"ios", "js", "linux", "nacl", "netbsd", "openbsd", "plan9", "solaris",
+		"wasip1", "windows",
+	}
+	knownArch = []string{
+		"386", "amd64", "amd64p32", "arm", "arm64", "loong64", "mips",
+		"mips64", "mips64le", "mipsle", "ppc64", "ppc64le", "riscv64", "s390x",
+		"wasm",
+	}
+)
+
+// MatchAnyFile is like MatchFile, but reports whether the file is built on
+// any known GOOS and GOARCH, with or without cgo, given the other tags in
+// bctx. Files are only left out if no platform builds them, as is the case
+// for files tagged "ignore".
+func MatchAnyFile(bctx build.Context, dir, name string) (bool, *Constraints, error) {
+	var c *Constraints
+	for _, goos := range knownOS {
+		for _, goarch := range knownArch {
+			for _, cgo := range []bool{false, true} {
+				pctx := bctx
+				pctx.GOOS, pctx.GOARCH, pctx.CgoEnabled = goos, goarch, cgo
+				if ok, err := MatchName(pctx, name); err != nil {
+					return false, nil, err
+				} else if !ok {
+					continue
+				}
+				if c == nil {
+					var err error
+					if c, err = ReadConstraints(filepath.Join(dir, name)); err != nil {
+						return false, nil, err
+					}
+				}
+				if c.Match(pctx) {
+					return true, c, nil
+				}
+			}
+		}
+	}
+	return false, c, nil
+}
+
+// filterDir returns a ReadDir function for build contexts that lists the
+// subdirectories of a directory and the files that match accepts, where match
+// is MatchFile or MatchAnyFile. warn is called for files whose //go:build
+// and +build lines disagree in bctx, and for files whose constraints cannot
+// be read, which are left out, much as go/build would mark them invalid
+// without giving up on the rest of the package.
+func filterDir(bctx build.Context, match func(bctx build.Context, dir, name string) (bool, *Constraints, error), warn func(path string, err error)) func(string) ([]os.FileInfo, error) {
+	return func(dir string) ([]os.FileInfo, error) {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		var kept []os.FileInfo
+		for _, info := range infos {
+			if info.IsDir() {
+				kept = append(kept, info)
+				continue
+			}
+			ok, c, err := match(bctx, dir, info.Name())
+			if err != nil {
+				warn(filepath.Join(dir, info.Name()), err)
+				continue
+			}
+			if c != nil && !c.Agree(bctx) {
+				warn(filepath.Join(dir, info.Name()), nil)
+			}
+			if ok {
+				kept = append(kept, info)
+			}
+		}
+		return kept, nil
+	}
+}
@@ -0,0 +1,195 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package packages_test
+
+import (
+	"bytes"
+	"go/build"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
+)
+
+func linuxContext() build.Context {
+	bctx := build.Default
+	bctx.GOOS = "linux"
+	bctx.GOARCH = "amd64"
+	bctx.CgoEnabled = false
+	bctx.BuildTags = nil
+	bctx.ReleaseTags = nil
+	return bctx
+}
+
+func TestParseConstraints(t *testing.T) {
+	for _, c := range []struct {
+		desc, content     string
+		goBuild           string
+		plusBuild         []string
+		wantLinux, agrees bool
+	}{
+		{
+			desc:      "none",
+			content:   "package p\n",
+			wantLinux: true,
+			agrees:    true,
+		},
+		{
+			desc:      "go:build only",
+			content:   "//go:build linux && !cgo\n\npackage p\n",
+			goBuild:   "//go:build linux && !cgo",
+			wantLinux: true,
+			agrees:    true,
+		},
+		{
+			desc:      "plus build only",
+			content:   "// +build darwin\n\npackage p\n",
+			plusBuild: []string{"// +build darwin"},
+			agrees:    true,
+		},
+		{
+			desc:      "both agree",
+			content:   "/* header */\n\n//go:build linux || darwin\n// +build linux darwin\n\npackage p\n",
+			goBuild:   "//go:build linux || darwin",
+			plusBuild: []string{"// +build linux darwin"},
+			wantLinux: true,
+			agrees:    true,
+		},
+		{
+			desc:      "both disagree",
+			content:   "//go:build darwin\n// +build linux\n\npackage p\n",
+			goBuild:   "//go:build darwin",
+			plusBuild: []string{"// +build linux"},
+			agrees:    false,
+		},
+		{
+			desc:      "plus build without blank line",
+			content:   "// +build ignore\npackage p\n",
+			wantLinux: true,
+			agrees:    true,
+		},
+		{
+			desc:      "after package clause",
+			content:   "package p\n\n//go:build ignore\n",
+			wantLinux: true,
+			agrees:    true,
+		},
+		{
+			desc:      "go:build precedence and parentheses",
+			content:   "//go:build !(darwin || windows) && (amd64 || arm64) && !go1.99\n\npackage p\n",
+			goBuild:   "//go:build !(darwin || windows) && (amd64 || arm64) && !go1.99",
+			wantLinux: true,
+			agrees:    true,
+		},
+		{
+			desc:      "plus build options and terms",
+			content:   "// +build darwin linux,!cgo\n// +build amd64\n\npackage p\n",
+			plusBuild: []string{"// +build darwin linux,!cgo", "// +build amd64"},
+			wantLinux: true,
+			agrees:    true,
+		},
+		{
+			desc:      "unix",
+			content:   "//go:build unix\n\npackage p\n",
+			goBuild:   "//go:build unix",
+			wantLinux: true,
+			agrees:    true,
+		},
+	} {
+		cs, err := packages.ParseConstraints([]byte(c.content))
+		if err != nil {
+			t.Errorf("%s: ParseConstraints failed with %v; want success", c.desc, err)
+			continue
+		}
+		if cs.GoBuild != c.goBuild {
+			t.Errorf("%s: GoBuild = %q; want %q", c.desc, cs.GoBuild, c.goBuild)
+		}
+		if !reflect.DeepEqual(cs.PlusBuild, c.plusBuild) {
+			t.Errorf("%s: PlusBuild = %q; want %q", c.desc, cs.PlusBuild, c.plusBuild)
+		}
+		bctx := linuxContext()
+		if got := cs.Match(bctx); got != c.wantLinux {
+			t.Errorf("%s: Match(linux) = %v; want %v", c.desc, got, c.wantLinux)
+		}
+		if got := cs.Agree(bctx); got != c.agrees {
+			t.Errorf("%s: Agree(linux) = %v; want %v", c.desc, got, c.agrees)
+		}
+	}
+}
+
+func TestParseConstraintsErrors(t *testing.T) {
+	for _, content := range []string{
+		"//go:build linux\n//go:build darwin\n\npackage p\n",
+		"//go:build linux &&\n\npackage p\n",
+		"//go:build (linux\n\npackage p\n",
+		"//go:build linux darwin\n\npackage p\n",
+		"// +build linux,!!cgo\n\npackage p\n",
+	} {
+		if _, err := packages.ParseConstraints([]byte(content)); err == nil {
+			t.Errorf("ParseConstraints(%q) succeeded; want error", content)
+		}
+	}
+}
+
+func TestWalkGoBuild(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, content := range map[string]string{
+		"lib.go":       "package lib\n",
+		"linux.go":     "//go:build linux\n\npackage lib\n",
+		"darwin.go":    "//go:build darwin\n\npackage lib\n",
+		"ignore.go":    "//go:build ignore\n\npackage main\n",
+		"disagree.go":  "//go:build linux\n// +build darwin\n\npackage lib\n",
+		"lib_plan9.go": "package lib\n",
+		"bad.go":       "//go:build linux &&\n\npackage lib\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("ioutil.WriteFile(%q) failed with %v; want success", path, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	var files []string
+	err = packages.Walk(linuxContext(), dir, func(pkg *build.Package) error {
+		files = append(files, pkg.GoFiles...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("packages.Walk(linux, %q, func) failed with %v; want success", dir, err)
+	}
+	if want := []string{"disagree.go", "lib.go", "linux.go"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("GoFiles = %q; want %q", files, want)
+	}
+	if got := buf.String(); !strings.Contains(got, "disagree.go: //go:build and +build lines disagree") {
+		t.Errorf("log output = %q; want a warning about disagree.go", got)
+	}
+	if got := buf.String(); !strings.Contains(got, "skipping "+filepath.Join(dir, "bad.go")) {
+		t.Errorf("log output = %q; want a warning about bad.go", got)
+	}
+}
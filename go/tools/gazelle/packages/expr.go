@@ -0,0 +1,216 @@
+/* Copyright 2017 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package packages
+
+import (
+	"fmt"
+	"strings"
+)
+
+// This file parses build constraint lines. It follows the syntax of
+// go/build/constraint, which is not available in the Go version that the
+// repository tools are built with.
+
+// expr is a parsed build constraint.
+type expr interface {
+	eval(ok func(tag string) bool) bool
+}
+
+type tagExpr string
+
+func (x tagExpr) eval(ok func(string) bool) bool { return ok(string(x)) }
+
+type notExpr struct{ x expr }
+
+func (x notExpr) eval(ok func(string) bool) bool { return !x.x.eval(ok) }
+
+type andExpr struct{ x, y expr }
+
+func (x andExpr) eval(ok func(string) bool) bool { return x.x.eval(ok) && x.y.eval(ok) }
+
+type orExpr struct{ x, y expr }
+
+func (x orExpr) eval(ok func(string) bool) bool { return x.x.eval(ok) || x.y.eval(ok) }
+
+// isGoBuild reports whether line is a //go:build constraint.
+func isGoBuild(line string) bool {
+	rest := strings.TrimPrefix(line, "//go:build")
+	return rest != line && (rest == "" || rest[0] == ' ' || rest[0] == '\t')
+}
+
+// isPlusBuild reports whether line is a +build constraint.
+func isPlusBuild(line string) bool {
+	if !strings.HasPrefix(line, "//") {
+		return false
+	}
+	line = strings.TrimSpace(line[2:])
+	rest := strings.TrimPrefix(line, "+build")
+	return rest != line && (rest == "" || rest[0] == ' ' || rest[0] == '\t')
+}
+
+// parseGoBuild parses a //go:build line, in which tags are combined with
+// !, &&, || and parentheses.
+func parseGoBuild(line string) (expr, error) {
+	p := &exprParser{s: strings.TrimPrefix(line, "//go:build")}
+	x := p.or()
+	if p.err == nil && p.next() != "" {
+		p.err = fmt.Errorf("unexpected %q", p.tok)
+	}
+	if p.err != nil {
+		return nil, fmt.Errorf("parsing %q: %v", line, p.err)
+	}
+	return x, nil
+}
+
+// parsePlusBuild parses a +build line, in which the space-separated options
+// are or'ed, and the comma-separated terms of each option are and'ed.
+func parsePlusBuild(line string) (expr, error) {
+	fields := strings.Fields(strings.TrimSpace(line[2:]))[1:]
+	var x expr
+	for _, option := range fields {
+		var y expr
+		for _, term := range strings.Split(option, ",") {
+			var z expr
+			name := strings.TrimPrefix(term, "!")
+			if strings.HasPrefix(name, "!") || !isTag(name) {
+				return nil, fmt.Errorf("parsing %q: invalid term %q", line, term)
+			}
+			z = tagExpr(name)
+			if name != term {
+				z = notExpr{z}
+			}
+			if y == nil {
+				y = z
+			} else {
+				y = andExpr{y, z}
+			}
+		}
+		if x == nil {
+			x = y
+		} else {
+			x = orExpr{x, y}
+		}
+	}
+	if x == nil {
+		return nil, fmt.Errorf("parsing %q: no options", line)
+	}
+	return x, nil
+}
+
+func isTag(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+type exprParser struct {
+	s   string
+	tok string
+	// pushed is set when tok has been read but not consumed.
+	pushed bool
+	err    error
+}
+
+// next returns the next token, or "" at the end of the line.
+func (p *exprParser) next() string {
+	if p.pushed {
+		p.pushed = false
+		return p.tok
+	}
+	p.s = strings.TrimLeft(p.s, " \t")
+	switch {
+	case p.s == "":
+		p.tok = ""
+	case strings.HasPrefix(p.s, "&&"), strings.HasPrefix(p.s, "||"):
+		p.tok = p.s[:2]
+	case p.s[0] == '!', p.s[0] == '(', p.s[0] == ')':
+		p.tok = p.s[:1]
+	default:
+		i := 0
+		for i < len(p.s) && isTag(p.s[i:i+1]) {
+			i++
+		}
+		if i == 0 {
+			p.err = fmt.Errorf("unexpected %q", p.s[:1])
+			p.s = ""
+			p.tok = ""
+			return ""
+		}
+		p.tok = p.s[:i]
+	}
+	p.s = p.s[len(p.tok):]
+	return p.tok
+}
+
+func (p *exprParser) unread() {
+	p.pushed = true
+}
+
+func (p *exprParser) or() expr {
+	x := p.and()
+	for p.err == nil {
+		if p.next() != "||" {
+			p.unread()
+			break
+		}
+		x = orExpr{x, p.and()}
+	}
+	return x
+}
+
+func (p *exprParser) and() expr {
+	x := p.not()
+	for p.err == nil {
+		if p.next() != "&&" {
+			p.unread()
+			break
+		}
+		x = andExpr{x, p.not()}
+	}
+	return x
+}
+
+func (p *exprParser) not() expr {
+	if p.err != nil {
+		return nil
+	}
+	switch tok := p.next(); {
+	case tok == "!":
+		return notExpr{p.not()}
+	case tok == "(":
+		x := p.or()
+		if p.err == nil && p.next() != ")" {
+			p.err = fmt.Errorf("missing )")
+		}
+		return x
+	case isTag(tok):
+		return tagExpr(tok)
+	case tok == "":
+		if p.err == nil {
+			p.err = fmt.Errorf("unexpected end of expression")
+		}
+		return nil
+	default:
+		p.err = fmt.Errorf("unexpected %q", tok)
+		return nil
+	}
+}
@@ -17,6 +17,7 @@ package packages
 
 import (
 	"go/build"
+	"log"
 	"os"
 	"path/filepath"
 )
@@ -30,7 +31,33 @@ type WalkFunc func(pkg *build.Package) error
 // It is similar to "golang.org/x/tools/go/buildutil".ForEachPackage, but
 // it does not assume the standard Go tree because Bazel rules_go uses
 // go_prefix instead of the standard tree.
+//
+// Build constraints are evaluated with MatchFile, so //go:build lines are
+// honored regardless of the Go version Walk was built with. A warning is
+// logged for files whose //go:build and +build lines disagree, and for files
+// whose build constraints cannot be parsed, which are skipped.
 func Walk(bctx build.Context, root string, f WalkFunc) error {
+	return walk(bctx, root, MatchFile, f)
+}
+
+// WalkAll is like Walk, but the packages it calls back for include the files
+// that are built on any platform, as decided by MatchAnyFile, rather than
+// only those for the GOOS and GOARCH of bctx. This gives the imports of a
+// package on every platform.
+func WalkAll(bctx build.Context, root string, f WalkFunc) error {
+	return walk(bctx, root, MatchAnyFile, f)
+}
+
+func walk(bctx build.Context, root string, match func(build.Context, string, string) (bool, *Constraints, error), f WalkFunc) error {
+	filter := bctx
+	bctx.UseAllFiles = true
+	bctx.ReadDir = filterDir(filter, match, func(path string, err error) {
+		if err != nil {
+			log.Printf("warning: skipping %s: %v", path, err)
+		} else {
+			log.Printf("warning: %s: //go:build and +build lines disagree", path)
+		}
+	})
 	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
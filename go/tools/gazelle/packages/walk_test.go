@@ -106,3 +106,39 @@ func TestWalkNested(t *testing.T) {
 		t.Errorf("pkgs = %q; want %q", got, want)
 	}
 }
+
+func TestWalkAll(t *testing.T) {
+	dir, err := tempDir()
+	if err != nil {
+		t.Fatalf("tempDir() failed with %v; want success", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, content := range map[string]string{
+		"lib.go":         "package lib\n\nimport \"example.com/all\"\n",
+		"lib_windows.go": "package lib\n\nimport \"example.com/windows\"\n",
+		"plan9.go":       "//go:build plan9 && arm\n\npackage lib\n\nimport \"example.com/plan9\"\n",
+		"nocgo.go":       "// +build !cgo\n\npackage lib\n\nimport \"example.com/nocgo\"\n",
+		"gen.go":         "//go:build ignore\n\npackage main\n\nimport \"example.com/gen\"\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("ioutil.WriteFile(%q, %q, 0600) failed with %v; want success", path, content, err)
+		}
+	}
+
+	bctx := build.Default
+	bctx.GOOS, bctx.GOARCH, bctx.CgoEnabled = "linux", "amd64", true
+	var imports []string
+	err = packages.WalkAll(bctx, dir, func(pkg *build.Package) error {
+		imports = append(imports, pkg.Imports...)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("packages.WalkAll(bctx, %q, func) failed with %v; want success", dir, err)
+	}
+	want := []string{"example.com/all", "example.com/nocgo", "example.com/plan9", "example.com/windows"}
+	if !reflect.DeepEqual(imports, want) {
+		t.Errorf("imports = %q; want %q", imports, want)
+	}
+}
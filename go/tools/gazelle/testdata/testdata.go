@@ -17,15 +17,14 @@ limitations under the License.
 package testdata
 
 import (
-	"os"
-	"path/filepath"
+	"github.com/bazelbuild/rules_go/go/runfiles"
 )
 
 // Dir returns a path to the testdata directory.
 func Dir() string {
-	srcdir := os.Getenv("TEST_SRCDIR")
-	return filepath.Join(
-		srcdir, os.Getenv("TEST_WORKSPACE"),
-		"go", "tools", "gazelle", "testdata",
-	)
+	dir, err := runfiles.Path("go/tools/gazelle/testdata")
+	if err != nil {
+		panic(err)
+	}
+	return dir
 }
@@ -19,13 +19,17 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/token"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"text/template"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Cases holds template data.
@@ -37,6 +41,13 @@ type Cases struct {
 	HasTestMain    bool
 }
 
+// diagnostics is a list of problems found in test files, one per line.
+type diagnostics []string
+
+func (d diagnostics) Error() string {
+	return strings.Join(d, "\n")
+}
+
 func main() {
 	pkg := flag.String("package", "", "package from which to import test methods.")
 	out := flag.String("output", "", "output file to write. Defaults to stdout.")
@@ -46,6 +57,18 @@ func main() {
 		log.Fatal("must set --package.")
 	}
 
+	cases := Cases{
+		Package: *pkg,
+		RunDir:  os.Getenv("RUNDIR"),
+	}
+	if err := cases.load(token.NewFileSet(), flag.Args()); err != nil {
+		if _, ok := err.(diagnostics); ok {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		log.Fatal(err)
+	}
+
 	outFile := os.Stdout
 	if *out != "" {
 		var err error
@@ -56,80 +79,28 @@ func main() {
 		defer outFile.Close()
 	}
 
-	cases := Cases{
-		Package: *pkg,
-		RunDir:  os.Getenv("RUNDIR"),
-	}
-	testFileSet := token.NewFileSet()
-	for _, f := range flag.Args() {
-		parse, err := parser.ParseFile(testFileSet, f, nil, parser.ParseComments)
-		if err != nil {
-			log.Fatalf("ParseFile(%q): %v", f, err)
-		}
-
-		for _, d := range parse.Decls {
-			fn, ok := d.(*ast.FuncDecl)
-			if !ok {
-				continue
-			}
-			if fn.Recv != nil {
-				continue
-			}
-			if fn.Name.Name == "TestMain" {
-				// TestMain is not, itself, a test
-				cases.HasTestMain = true
-				continue
-			}
-
-			// Here we check the signature of the Test* function. To
-			// be considered a test:
-
-			// 1. The function should have a single argument.
-			if len(fn.Type.Params.List) != 1 {
-				continue
-			}
-
-			// 2. The function should return nothing.
-			if fn.Type.Results != nil {
-				continue
-			}
-
-			// 3. The only parameter should have a type identified as
-			//    *<something>.T
-			starExpr, ok := fn.Type.Params.List[0].Type.(*ast.StarExpr)
-			if !ok {
-				continue
-			}
-			selExpr, ok := starExpr.X.(*ast.SelectorExpr)
-			if !ok {
-				continue
-			}
-
-			// We do not descriminate on the referenced type of the
-			// parameter being *testing.T. Instead we assert that it
-			// should be *<something>.T. This is because the import
-			// could have been aliased as a different identifier.
-
-			if strings.HasPrefix(fn.Name.Name, "Test") {
-				if selExpr.Sel.Name != "T" {
-					continue
-				}
-				cases.TestNames = append(cases.TestNames, fn.Name.Name)
-			}
-			if strings.HasPrefix(fn.Name.Name, "Benchmark") {
-				if selExpr.Sel.Name != "B" {
-					continue
-				}
-				cases.BenchmarkNames = append(cases.BenchmarkNames, fn.Name.Name)
-			}
-		}
+	if err := testMainTpl.Execute(outFile, &cases); err != nil {
+		log.Fatalf("template.Execute(%v): %v", cases, err)
 	}
+}
 
-	tpl := template.Must(template.New("source").Parse(`
+// testMainTpl generates the main package of a test from Cases.
+var testMainTpl = template.Must(template.New("source").Parse(`
 package main
 import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 {{ if .TestNames }}
         undertest "{{.Package}}"
@@ -154,17 +125,479 @@ var benchmarks = []testing.InternalBenchmark{
 {{end}}
 }
 
+var (
+	junitRunLine    = regexp.MustCompile(` + "`" + `^=== RUN\s+([^/\s]+)` + "`" + `)
+	junitResultLine = regexp.MustCompile(` + "`" + `^--- (PASS|FAIL|SKIP): (\S+) \(` + "`" + `)
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name         ` + "`" + `xml:"testsuites"` + "`" + `
+	Suites  []junitTestSuite ` + "`" + `xml:"testsuite"` + "`" + `
+}
+
+type junitTestSuite struct {
+	Name     string           ` + "`" + `xml:"name,attr"` + "`" + `
+	Tests    int              ` + "`" + `xml:"tests,attr"` + "`" + `
+	Failures int              ` + "`" + `xml:"failures,attr"` + "`" + `
+	Errors   int              ` + "`" + `xml:"errors,attr"` + "`" + `
+	Skipped  int              ` + "`" + `xml:"skipped,attr"` + "`" + `
+	Time     string           ` + "`" + `xml:"time,attr"` + "`" + `
+	Cases    []junitTestCase ` + "`" + `xml:"testcase"` + "`" + `
+}
+
+type junitTestCase struct {
+	ClassName string        ` + "`" + `xml:"classname,attr"` + "`" + `
+	Name      string        ` + "`" + `xml:"name,attr"` + "`" + `
+	Time      string        ` + "`" + `xml:"time,attr"` + "`" + `
+	Failure   *junitMessage ` + "`" + `xml:"failure,omitempty"` + "`" + `
+	Skipped   *junitMessage ` + "`" + `xml:"skipped,omitempty"` + "`" + `
+	SystemOut string        ` + "`" + `xml:"system-out,omitempty"` + "`" + `
+}
+
+type junitMessage struct {
+	Message  string ` + "`" + `xml:"message,attr"` + "`" + `
+	Contents string ` + "`" + `xml:",chardata"` + "`" + `
+}
+
+// junitResult is the outcome of a single top-level test.
+type junitResult struct {
+	name     string
+	duration time.Duration
+	started  bool
+	done     bool
+	failed   bool
+	skipped  bool
+	output   []string
+}
+
+// junitReporter records the outcome of each test and writes them to
+// XML_OUTPUT_FILE as a JUnit-compatible report.
+type junitReporter struct {
+	path  string
+	suite string
+	start time.Time
+
+	mu      sync.Mutex
+	results []*junitResult
+	byName  map[string]*junitResult
+	current *junitResult
+	verbose bool
+
+	stdout    *os.File
+	pipe      *os.File
+	drained   chan struct{}
+	closeOnce sync.Once
+}
+
+// newJUnitReporter creates a result for each of tests up front, so that
+// output can be attributed to a test as soon as its "=== RUN" line appears,
+// before the test function itself starts.
+func newJUnitReporter(path, suite string, tests []testing.InternalTest) *junitReporter {
+	r := &junitReporter{
+		path:   path,
+		suite:  suite,
+		start:  time.Now(),
+		byName: make(map[string]*junitResult),
+	}
+	for _, t := range tests {
+		res := &junitResult{name: t.Name}
+		r.results = append(r.results, res)
+		r.byName[t.Name] = res
+	}
+	return r
+}
+
+// captureOutput redirects os.Stdout through a pipe so that the output
+// printed for each test can be attached to its result. Everything is still
+// copied to the original stdout as it arrives.
+func (r *junitReporter) captureOutput() {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not capture test output: %v\n", err)
+		return
+	}
+	r.stdout = os.Stdout
+	r.pipe = pw
+	r.drained = make(chan struct{})
+	os.Stdout = pw
+	go r.copyOutput(pr)
+}
+
+func (r *junitReporter) copyOutput(in io.Reader) {
+	defer close(r.drained)
+	buf := make([]byte, 4096)
+	partial := ""
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			r.stdout.Write(buf[:n])
+			partial += string(buf[:n])
+			for {
+				i := strings.IndexByte(partial, '\n')
+				if i < 0 {
+					break
+				}
+				r.parseLine(partial[:i])
+				partial = partial[i+1:]
+			}
+		}
+		if err != nil {
+			if partial != "" {
+				r.parseLine(partial)
+			}
+			return
+		}
+	}
+}
+
+// parseLine attributes a line of test output to the test that printed it.
+// Indented lines following a "--- FAIL" or "--- SKIP" header belong to that
+// test; with -test.v, everything following "=== RUN" does too.
+func (r *junitReporter) parseLine(line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m := junitRunLine.FindStringSubmatch(line); m != nil {
+		r.current = r.byName[m[1]]
+		r.verbose = true
+		return
+	}
+	if m := junitResultLine.FindStringSubmatch(line); m != nil {
+		r.current = r.byName[m[2]]
+		r.verbose = false
+		return
+	}
+	if line == "PASS" || line == "FAIL" {
+		r.current = nil
+		return
+	}
+	if r.current == nil {
+		return
+	}
+	if r.verbose || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+		r.current.output = append(r.current.output, line)
+	}
+}
+
+// wrap returns a test function that records the outcome of f.
+func (r *junitReporter) wrap(name string, f func(*testing.T)) func(*testing.T) {
+	return func(t *testing.T) {
+		res := r.begin(name)
+		start := time.Now()
+		defer func() {
+			if p := recover(); p != nil {
+				// The panic will take the whole process down, so write
+				// the report before passing it on.
+				r.mu.Lock()
+				res.output = append(res.output, fmt.Sprintf("panic: %v", p))
+				r.mu.Unlock()
+				r.finish(res, time.Since(start), true, false)
+				r.close()
+				panic(p)
+			}
+			r.finish(res, time.Since(start), t.Failed(), t.Skipped())
+		}()
+		f(t)
+	}
+}
+
+func (r *junitReporter) begin(name string) *junitResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := r.byName[name]
+	res.started = true
+	return res
+}
+
+func (r *junitReporter) finish(res *junitResult, d time.Duration, failed, skipped bool) {
+	r.mu.Lock()
+	res.duration = d
+	res.done = true
+	res.failed = failed
+	res.skipped = skipped
+	r.mu.Unlock()
+	// Keep the report up to date, since a TestMain may exit the process
+	// before close is called.
+	if err := r.write(); err != nil {
+		fmt.Fprintf(os.Stderr, "could not write test report: %v\n", err)
+	}
+}
+
+// close stops capturing output, waits for captured output to be attributed
+// and writes the final report.
+func (r *junitReporter) close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		if r.pipe != nil {
+			os.Stdout = r.stdout
+			r.pipe.Close()
+			<-r.drained
+		}
+		err = r.write()
+	})
+	return err
+}
+
+func (r *junitReporter) write() error {
+	r.mu.Lock()
+	suite := junitTestSuite{
+		Name: r.suite,
+		Time: junitSeconds(time.Since(r.start)),
+	}
+	for _, res := range r.results {
+		if !res.started {
+			// Not run yet, or excluded by -test.run.
+			continue
+		}
+		c := junitTestCase{
+			ClassName: r.suite,
+			Name:      res.name,
+			Time:      junitSeconds(res.duration),
+		}
+		output := strings.Join(res.output, "\n")
+		switch {
+		case !res.done:
+			// Still running; most likely the process was killed.
+			c.Failure = &junitMessage{Message: "Did not complete", Contents: output}
+			suite.Failures++
+		case res.failed:
+			c.Failure = &junitMessage{Message: "Failed", Contents: output}
+			suite.Failures++
+		case res.skipped:
+			c.Skipped = &junitMessage{Message: "Skipped", Contents: output}
+			suite.Skipped++
+		default:
+			c.SystemOut = output
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	suite.Tests = len(suite.Cases)
+	r.mu.Unlock()
+
+	b, err := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{suite}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.path, append([]byte(xml.Header), b...), 0666)
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// startTimeout arranges for the test to fail with a dump of all goroutines
+// shortly before Bazel kills it for running longer than TEST_TIMEOUT.
+func startTimeout(reporter *junitReporter) {
+	secs, err := strconv.Atoi(os.Getenv("TEST_TIMEOUT"))
+	if err != nil || secs <= 0 {
+		return
+	}
+	timeout := time.Duration(secs) * time.Second
+	grace := timeout / 20
+	if grace < time.Second {
+		grace = time.Second
+	}
+	if grace >= timeout {
+		return
+	}
+	time.AfterFunc(timeout-grace, func() {
+		timedOut(timeout-grace, reporter)
+	})
+}
+
+func timedOut(after time.Duration, reporter *junitReporter) {
+	stacks := goroutineStacks()
+	fmt.Fprintf(os.Stderr, "test timed out after %v\n\n%s\n", after, stacks)
+	if dir := os.Getenv("TEST_UNDECLARED_OUTPUTS_DIR"); dir != "" {
+		path := filepath.Join(dir, "goroutines.txt")
+		if err := ioutil.WriteFile(path, stacks, 0666); err != nil {
+			fmt.Fprintf(os.Stderr, "could not write goroutine stacks: %v\n", err)
+		}
+	}
+	if reporter != nil {
+		if err := reporter.write(); err != nil {
+			fmt.Fprintf(os.Stderr, "could not write test report: %v\n", err)
+		}
+	}
+	os.Exit(1)
+}
+
+func goroutineStacks() []byte {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
 func main() {
   os.Chdir("{{.RunDir}}")
+
+  // Bazel asks for a JUnit-compatible report by setting XML_OUTPUT_FILE.
+  var reporter *junitReporter
+  if path := os.Getenv("XML_OUTPUT_FILE"); path != "" {
+    reporter = newJUnitReporter(path, "{{.Package}}", tests)
+    for i := range tests {
+      tests[i].F = reporter.wrap(tests[i].Name, tests[i].F)
+    }
+  }
+
+  startTimeout(reporter)
+
+  m := testing.MainStart(everything, tests, benchmarks, nil)
   {{if not .HasTestMain}}
-  testing.Main(everything, tests, benchmarks, nil)
+  // Bazel fails the test if this file still exists when the process exits,
+  // which catches tests that call os.Exit before all tests have run.
+  prematureExitFile := os.Getenv("TEST_PREMATURE_EXIT_FILE")
+  if prematureExitFile != "" {
+    if err := ioutil.WriteFile(prematureExitFile, nil, 0666); err != nil {
+      fmt.Fprintf(os.Stderr, "could not create premature exit file: %v\n", err)
+    }
+  }
+  if reporter != nil {
+    reporter.captureOutput()
+  }
+  code := m.Run()
+  if prematureExitFile != "" {
+    os.Remove(prematureExitFile)
+  }
+  if reporter != nil {
+    if err := reporter.close(); err != nil {
+      fmt.Fprintf(os.Stderr, "could not write test report: %v\n", err)
+      if code == 0 {
+        code = 1
+      }
+    }
+  }
+  os.Exit(code)
   {{else}}
-  m := testing.MainStart(everything, tests, benchmarks, nil)
+  // The premature exit file is left to TestMain, which may exit as soon as
+  // m.Run returns; see testenv.MarkPrematureExit.
   undertest.TestMain(m)
   {{end}}
 }
 `))
-	if err := tpl.Execute(outFile, &cases); err != nil {
-		log.Fatalf("template.Execute(%v): %v", cases, err)
+
+// load parses the given test files and records the tests, benchmarks and
+// TestMain they declare. Functions that look like tests, benchmarks or
+// examples but would not be run by "go test" are reported as diagnostics.
+// Like "go test", load only looks in files ending in _test.go, so helpers in
+// the library sources are neither run nor reported.
+func (c *Cases) load(fset *token.FileSet, filenames []string) error {
+	var diags diagnostics
+	for _, filename := range filenames {
+		if !strings.HasSuffix(filename, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+		if err != nil {
+			return fmt.Errorf("ParseFile(%q): %v", filename, err)
+		}
+		testing := testingName(f)
+		for _, d := range f.Decls {
+			fn, ok := d.(*ast.FuncDecl)
+			if !ok || fn.Recv != nil {
+				continue
+			}
+			if msg := c.addFunc(fn, testing); msg != "" {
+				diags = append(diags, fmt.Sprintf("%s: %s", fset.Position(fn.Pos()), msg))
+			}
+		}
+	}
+	if diags != nil {
+		return diags
+	}
+	return nil
+}
+
+// addFunc records fn if it is a test, benchmark or TestMain. testing is the
+// name under which fn's file imports the "testing" package. It returns a
+// description of the problem if fn is a malformed test function.
+func (c *Cases) addFunc(fn *ast.FuncDecl, testing string) string {
+	name := fn.Name.Name
+	switch {
+	case name == "TestMain" && hasParam(fn, testing, "M"):
+		// TestMain is not, itself, a test
+		c.HasTestMain = true
+	case name == "TestMain" && !hasParam(fn, testing, "T"):
+		return "wrong signature for TestMain, must be: func TestMain(m *testing.M)"
+	case isTest(name, "Test"):
+		if !hasParam(fn, testing, "T") {
+			return fmt.Sprintf("wrong signature for %s, must be: func %s(t *testing.T)", name, name)
+		}
+		c.TestNames = append(c.TestNames, name)
+	case isTest(name, "Benchmark"):
+		if !hasParam(fn, testing, "B") {
+			return fmt.Sprintf("wrong signature for %s, must be: func %s(b *testing.B)", name, name)
+		}
+		c.BenchmarkNames = append(c.BenchmarkNames, name)
+	case isTest(name, "Example"):
+		if len(fn.Type.Params.List) > 0 {
+			return fmt.Sprintf("%s should be niladic", name)
+		}
+		if fn.Type.Results != nil && len(fn.Type.Results.List) > 0 {
+			return fmt.Sprintf("%s should return nothing", name)
+		}
+	case strings.HasPrefix(name, "Test") && hasParam(fn, testing, "T"):
+		return fmt.Sprintf("%s has malformed name: first letter after 'Test' must not be lowercase", name)
+	case strings.HasPrefix(name, "Benchmark") && hasParam(fn, testing, "B"):
+		return fmt.Sprintf("%s has malformed name: first letter after 'Benchmark' must not be lowercase", name)
+	}
+	return ""
+}
+
+// testingName returns the name by which f refers to the "testing" package.
+// This is "" if f does not import it and "." if it is dot-imported.
+func testingName(f *ast.File) string {
+	for _, imp := range f.Imports {
+		if path, err := strconv.Unquote(imp.Path.Value); err != nil || path != "testing" {
+			continue
+		}
+		if imp.Name != nil {
+			return imp.Name.Name
+		}
+		return "testing"
+	}
+	return ""
+}
+
+// hasParam reports whether fn returns nothing and takes a single parameter
+// of type *testing.<typ>, where testing is the name of the testing package
+// in fn's file.
+func hasParam(fn *ast.FuncDecl, testing, typ string) bool {
+	if fn.Type.Results != nil && len(fn.Type.Results.List) > 0 {
+		return false
+	}
+	params := fn.Type.Params.List
+	if len(params) != 1 || len(params[0].Names) > 1 {
+		return false
+	}
+	star, ok := params[0].Type.(*ast.StarExpr)
+	if !ok {
+		return false
+	}
+	switch x := star.X.(type) {
+	case *ast.Ident:
+		return testing == "." && x.Name == typ
+	case *ast.SelectorExpr:
+		pkg, ok := x.X.(*ast.Ident)
+		return ok && testing != "" && pkg.Name == testing && x.Sel.Name == typ
+	}
+	return false
+}
+
+// isTest tells whether name looks like a test (or benchmark, according to
+// prefix). It is a Test (say) if there is a character after Test that is not
+// a lower-case letter. We don't want TesticularCancer.
+func isTest(name, prefix string) bool {
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	if len(name) == len(prefix) { // "Test" is ok
+		return true
 	}
+	r, _ := utf8.DecodeRuneInString(name[len(prefix):])
+	return !unicode.IsLower(r)
 }
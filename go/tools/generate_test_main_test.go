@@ -0,0 +1,434 @@
+/* Copyright 2016 The Bazel Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/rules_go/go/runfiles"
+)
+
+func loadCases(t *testing.T, files map[string]string) (Cases, error) {
+	dir, err := ioutil.TempDir("", "generate_test_main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var filenames []string
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		filenames = append(filenames, path)
+	}
+	sort.Strings(filenames)
+	var c Cases
+	err = c.load(token.NewFileSet(), filenames)
+	return c, err
+}
+
+func TestLoadValid(t *testing.T) {
+	c, err := loadCases(t, map[string]string{
+		"a_test.go": `package a
+
+import "testing"
+
+func TestMain(m *testing.M) {}
+func Test(t *testing.T) {}
+func TestA(t *testing.T) {}
+func Test_b(t *testing.T) {}
+func Testify() {}
+func BenchmarkA(b *testing.B) {}
+func ExampleA() {}
+func (x) TestMethod(t *testing.T) {}
+`,
+		"b_test.go": `package a
+
+import tt "testing"
+
+func TestAlias(t *tt.T) {}
+`,
+		"c_test.go": `package a
+
+import . "testing"
+
+func TestDot(t *T) {}
+func BenchmarkDot(b *B) {}
+`,
+	})
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if !c.HasTestMain {
+		t.Errorf("HasTestMain = false; want true")
+	}
+	if got, want := c.TestNames, []string{"Test", "TestA", "Test_b", "TestAlias", "TestDot"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TestNames = %v; want %v", got, want)
+	}
+	if got, want := c.BenchmarkNames, []string{"BenchmarkA", "BenchmarkDot"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("BenchmarkNames = %v; want %v", got, want)
+	}
+}
+
+func TestLoadDiagnostics(t *testing.T) {
+	_, err := loadCases(t, map[string]string{
+		"a_test.go": `package a
+
+import (
+	"testing"
+	other "example.com/other"
+)
+
+func TestInt(x int) {}
+func TestB(b *testing.B) {}
+func TestOther(t *other.T) {}
+func TestResult(t *testing.T) error { return nil }
+func TestMain(t *testing.B) {}
+func Testlower(t *testing.T) {}
+func BenchmarkT(t *testing.T) {}
+func Benchmarklower(b *testing.B) {}
+func ExampleArgs(x int) {}
+func ExampleResult() int { return 0 }
+`,
+	})
+	diags, ok := err.(diagnostics)
+	if !ok {
+		t.Fatalf("got error %v; want diagnostics", err)
+	}
+	want := []string{
+		"a_test.go:8:1: wrong signature for TestInt, must be: func TestInt(t *testing.T)",
+		"a_test.go:9:1: wrong signature for TestB, must be: func TestB(t *testing.T)",
+		"a_test.go:10:1: wrong signature for TestOther, must be: func TestOther(t *testing.T)",
+		"a_test.go:11:1: wrong signature for TestResult, must be: func TestResult(t *testing.T)",
+		"a_test.go:12:1: wrong signature for TestMain, must be: func TestMain(m *testing.M)",
+		"a_test.go:13:1: Testlower has malformed name: first letter after 'Test' must not be lowercase",
+		"a_test.go:14:1: wrong signature for BenchmarkT, must be: func BenchmarkT(b *testing.B)",
+		"a_test.go:15:1: Benchmarklower has malformed name: first letter after 'Benchmark' must not be lowercase",
+		"a_test.go:16:1: ExampleArgs should be niladic",
+		"a_test.go:17:1: ExampleResult should return nothing",
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("got %d diagnostics; want %d:\n%v", len(diags), len(want), err)
+	}
+	for i := range want {
+		if !strings.HasSuffix(diags[i], want[i]) {
+			t.Errorf("diagnostic %d: got %q; want suffix %q", i, diags[i], want[i])
+		}
+	}
+}
+
+func TestLoadNoTestingImport(t *testing.T) {
+	_, err := loadCases(t, map[string]string{
+		"a_test.go": `package a
+
+import testing "example.com/fake"
+
+func TestFake(t *testing.T) {}
+`,
+	})
+	if _, ok := err.(diagnostics); !ok {
+		t.Errorf("got error %v; want diagnostics", err)
+	}
+}
+
+func TestLoadNonTestFiles(t *testing.T) {
+	c, err := loadCases(t, map[string]string{
+		"a_test.go": `package a
+
+import "testing"
+
+func TestA(t *testing.T) {}
+`,
+		"helpers.go": `package a
+
+import "testing"
+
+func TestConn(t *testing.T, addr string) {}
+func TestHelper(t *testing.T) {}
+`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.TestNames, []string{"TestA"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TestNames = %v; want %v", got, want)
+	}
+}
+
+// buildTestMain generates the main package of a test for a package with the
+// given files and builds it with the Go toolchain among the runfiles. Like
+// rules_go, it compiles the _test.go files into the package under test.
+// It returns the path of the test binary. The test is skipped if the
+// toolchain is not found, since the generated main is written for that
+// version of Go.
+func buildTestMain(t *testing.T, dir string, files map[string]string) string {
+	goTool, err := runfiles.Rlocation("io_bazel_rules_go_toolchain/bin/go")
+	if err != nil {
+		t.Skipf("Go toolchain not found: %v", err)
+	}
+	if goTool, err = filepath.EvalSymlinks(goTool); err != nil {
+		t.Fatal(err)
+	}
+
+	pkgDir := filepath.Join(dir, "src", "example.com", "lib")
+	mainDir := filepath.Join(dir, "src", "example.com", "lib_test_main")
+	for _, d := range []string{pkgDir, mainDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var filenames []string
+	for name, content := range files {
+		path := filepath.Join(pkgDir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		filenames = append(filenames, path)
+	}
+	sort.Strings(filenames)
+	c := Cases{Package: "example.com/lib", RunDir: dir}
+	if err := c.load(token.NewFileSet(), filenames); err != nil {
+		t.Fatal(err)
+	}
+	// "go build" skips _test.go files, but rules_go compiles them into the
+	// library, so give them plain names before building.
+	for _, path := range filenames {
+		if strings.HasSuffix(path, "_test.go") {
+			if err := os.Rename(path, strings.TrimSuffix(path, "_test.go")+"_xtest.go"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	var src bytes.Buffer
+	if err := testMainTpl.Execute(&src, &c); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(mainDir, "main.go"), src.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bin := filepath.Join(dir, "lib_test")
+	cmd := exec.Command(goTool, "build", "-o", bin, "example.com/lib_test_main")
+	cmd.Env = append(os.Environ(), "GOPATH="+dir, "GOROOT="+filepath.Dir(filepath.Dir(goTool)))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go build failed with %v:\n%s", err, out)
+	}
+	return bin
+}
+
+// runTestMain runs a test binary built by buildTestMain with the given
+// environment variables and returns its output. It fails the test if the
+// binary succeeds, since each test here has a failing case.
+func runTestMain(t *testing.T, bin string, env []string, args ...string) string {
+	cmd := exec.Command(bin, args...)
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.CombinedOutput()
+	if _, ok := err.(*exec.ExitError); !ok {
+		t.Fatalf("%s exited with %v; want a failure:\n%s", bin, err, out)
+	}
+	return string(out)
+}
+
+type junitReport struct {
+	Suites []struct {
+		Tests    int `xml:"tests,attr"`
+		Failures int `xml:"failures,attr"`
+		Skipped  int `xml:"skipped,attr"`
+		Cases    []struct {
+			Name    string `xml:"name,attr"`
+			Failure *struct {
+				Message  string `xml:"message,attr"`
+				Contents string `xml:",chardata"`
+			} `xml:"failure"`
+			Skipped *struct {
+				Contents string `xml:",chardata"`
+			} `xml:"skipped"`
+			SystemOut string `xml:"system-out"`
+		} `xml:"testcase"`
+	} `xml:"testsuite"`
+}
+
+func readJUnitReport(t *testing.T, path string) *junitReport {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r junitReport
+	if err := xml.Unmarshal(b, &r); err != nil {
+		t.Fatalf("%s: %v\n%s", path, err, b)
+	}
+	if len(r.Suites) != 1 {
+		t.Fatalf("%s has %d test suites; want 1:\n%s", path, len(r.Suites), b)
+	}
+	return &r
+}
+
+func TestJUnitReport(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "generate_test_main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bin := buildTestMain(t, dir, map[string]string{
+		"lib_test.go": `package lib
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPass(t *testing.T) {
+	fmt.Println("output of TestPass")
+}
+
+func TestFail(t *testing.T) {
+	fmt.Println("output of TestFail")
+	t.Error("error in TestFail")
+}
+
+func TestSkip(t *testing.T) {
+	t.Skip("reason for TestSkip")
+}
+`,
+	})
+
+	for _, verbose := range []bool{false, true} {
+		xmlPath := filepath.Join(dir, "test.xml")
+		var args []string
+		if verbose {
+			args = []string{"-test.v"}
+		}
+		out := runTestMain(t, bin, []string{"XML_OUTPUT_FILE=" + xmlPath}, args...)
+		if !strings.Contains(out, "output of TestPass") {
+			t.Errorf("output of TestPass was not passed through:\n%s", out)
+		}
+
+		suite := readJUnitReport(t, xmlPath).Suites[0]
+		if suite.Tests != 3 || suite.Failures != 1 || suite.Skipped != 1 {
+			t.Errorf("verbose %v: got %d tests, %d failures and %d skipped; want 3, 1 and 1", verbose, suite.Tests, suite.Failures, suite.Skipped)
+		}
+		if len(suite.Cases) != 3 {
+			t.Fatalf("verbose %v: got %d test cases; want 3", verbose, len(suite.Cases))
+		}
+		pass, fail, skip := suite.Cases[0], suite.Cases[1], suite.Cases[2]
+		if pass.Name != "TestPass" || fail.Name != "TestFail" || skip.Name != "TestSkip" {
+			t.Errorf("verbose %v: got test cases %s, %s and %s; want TestPass, TestFail and TestSkip", verbose, pass.Name, fail.Name, skip.Name)
+		}
+		if pass.Failure != nil || pass.Skipped != nil || fail.Failure == nil || skip.Skipped == nil {
+			t.Fatalf("verbose %v: results of TestPass, TestFail and TestSkip are wrong: %+v", verbose, suite.Cases)
+		}
+		if !strings.Contains(fail.Failure.Contents, "error in TestFail") {
+			t.Errorf("verbose %v: failure of TestFail is %q; want the error", verbose, fail.Failure.Contents)
+		}
+		if !verbose {
+			continue
+		}
+		// Only with -test.v are skips logged, and is output printed
+		// outside of test logs attributed to the test that printed it.
+		if !strings.Contains(skip.Skipped.Contents, "reason for TestSkip") {
+			t.Errorf("skip of TestSkip is %q; want the reason", skip.Skipped.Contents)
+		}
+		if !strings.Contains(pass.SystemOut, "output of TestPass") || strings.Contains(pass.SystemOut, "TestFail") {
+			t.Errorf("output of TestPass is %q; want only its own output", pass.SystemOut)
+		}
+		if !strings.Contains(fail.Failure.Contents, "output of TestFail") || strings.Contains(fail.Failure.Contents, "TestPass") {
+			t.Errorf("failure of TestFail is %q; want only its own output", fail.Failure.Contents)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "generate_test_main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bin := buildTestMain(t, dir, map[string]string{
+		"lib_test.go": `package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHang(t *testing.T) {
+	waitForever()
+}
+
+func waitForever() {
+	time.Sleep(time.Hour)
+}
+`,
+	})
+
+	outputs := filepath.Join(dir, "outputs")
+	if err := os.Mkdir(outputs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	xmlPath := filepath.Join(dir, "test.xml")
+	cmd := exec.Command(bin)
+	// With a timeout of 2s, the goroutines are dumped after 1s.
+	cmd.Env = append(os.Environ(),
+		"TEST_TIMEOUT=2",
+		"TEST_UNDECLARED_OUTPUTS_DIR="+outputs,
+		"XML_OUTPUT_FILE="+xmlPath)
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	timer := time.AfterFunc(time.Minute, func() { cmd.Process.Kill() })
+	err = cmd.Wait()
+	out := buf.String()
+	if !timer.Stop() {
+		t.Fatalf("test did not exit before its timeout:\n%s", out)
+	}
+	if _, ok := err.(*exec.ExitError); !ok {
+		t.Fatalf("test exited with %v; want a failure:\n%s", err, out)
+	}
+
+	if !strings.Contains(out, "test timed out after 1s") {
+		t.Errorf("output does not report the timeout:\n%s", out)
+	}
+	if !strings.Contains(out, "example.com/lib.waitForever") {
+		t.Errorf("output does not have the stack of the hanging test:\n%s", out)
+	}
+	stacks, err := ioutil.ReadFile(filepath.Join(outputs, "goroutines.txt"))
+	if err != nil {
+		t.Errorf("goroutine stacks were not written: %v", err)
+	} else if !strings.Contains(string(stacks), "example.com/lib.waitForever") {
+		t.Errorf("goroutines.txt does not have the stack of the hanging test:\n%s", stacks)
+	}
+
+	suite := readJUnitReport(t, xmlPath).Suites[0]
+	if len(suite.Cases) != 1 || suite.Cases[0].Failure == nil || suite.Cases[0].Failure.Message != "Did not complete" {
+		t.Errorf("report = %+v; want TestHang to have not completed", suite)
+	}
+}
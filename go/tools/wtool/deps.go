@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	bzl "github.com/bazelbuild/buildifier/build"
+	"github.com/bazelbuild/rules_go/go/runfiles"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/packages"
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/rules"
+	"golang.org/x/tools/go/vcs"
+)
+
+// depFinder discovers the repositories that Go repositories depend on and
+// adds the ones that are missing to a WORKSPACE file.
+type depFinder struct {
+	f *bzl.File
+	w io.Writer
+	// repoRoot resolves an import path to the repository that contains it.
+	repoRoot func(importpath string) (*vcs.RepoRoot, error)
+	// roots are the repositories that have been visited, by root import
+	// path.
+	roots map[string]bool
+	// mirror and cache are passed to fetch_repo, as go_repository does
+	// with GO_REPOSITORY_MIRROR and GO_REPOSITORY_CACHE.
+	mirror, cache string
+}
+
+func newDepFinder(f *bzl.File, w io.Writer) *depFinder {
+	return &depFinder{
+		f: f,
+		w: w,
+		repoRoot: func(importpath string) (*vcs.RepoRoot, error) {
+			return vcs.RepoRootForImportPath(importpath, *verbose)
+		},
+		roots:  make(map[string]bool),
+		mirror: os.Getenv("GO_REPOSITORY_MIRROR"),
+		cache:  os.Getenv("GO_REPOSITORY_CACHE"),
+	}
+}
+
+// addDeps fetches r at the revision of repo, adds a rule for each repository
+// it imports packages from that is not in WORKSPACE yet, and does the same
+// for those repositories in turn. Each rule that is added is printed,
+// indented by its depth in the dependency tree below repo.
+func (d *depFinder) addDeps(repo *repository, r *vcs.RepoRoot, indent string) error {
+	d.roots[r.Root] = true
+	tmp, err := ioutil.TempDir("", "wtool")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, "src")
+	_, rev := repo.revision()
+	if err := d.fetch(r, rev, dir); err != nil {
+		return fmt.Errorf("fetching %s: %v", repo.importpath, err)
+	}
+	imports, err := externalImports(dir, r.Root)
+	if err != nil {
+		return fmt.Errorf("%s: %v", repo.importpath, err)
+	}
+
+	deps, err := d.resolveRoots(imports)
+	if err != nil {
+		return err
+	}
+	for _, dep := range deps {
+		if d.roots[dep.Root] {
+			continue
+		}
+		d.roots[dep.Root] = true
+		name := rules.ImportPathToBazelRepoName(dep.Root)
+		if findRepository(d.f, name, dep.Root) != nil {
+			continue
+		}
+		commit, _, err := latestRevision(dep.VCS, dep.Repo, "")
+		if err != nil {
+			return err
+		}
+		child := &repository{name: name, importpath: dep.Root, commit: commit}
+		fmt.Fprintln(d.w, indent+updateRepository(d.f, child))
+		if err := d.addDeps(child, dep, indent+"  "); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetch checks out r at rev in dest with fetch_repo, the way go_repository
+// does, so that the mirror and cache are used and import paths are not
+// resolved again.
+func (d *depFinder) fetch(r *vcs.RepoRoot, rev, dest string) error {
+	tool, err := fetchRepoTool()
+	if err != nil {
+		return err
+	}
+	args := []string{
+		"-dest", dest,
+		"-remote", r.Repo,
+		"-rev", rev,
+		"-vcs", r.VCS.Cmd,
+		"-importpath", r.Root,
+	}
+	if d.mirror != "" {
+		args = append(args, "-mirror", d.mirror)
+	}
+	if d.cache != "" {
+		args = append(args, "-cache", d.cache)
+	}
+	if out, err := exec.Command(tool, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("fetch_repo: %v\n%s", err, out)
+	}
+	return nil
+}
+
+// fetchRepoTool returns the path of the fetch_repo binary: the one given
+// with -fetch_repo, the one among the runfiles of wtool, or the one in PATH.
+func fetchRepoTool() (string, error) {
+	if *fetchBin != "" {
+		return *fetchBin, nil
+	}
+	if p, err := runfiles.Rlocation("io_bazel_rules_go/go/tools/fetch_repo/fetch_repo"); err == nil {
+		return p, nil
+	}
+	p, err := exec.LookPath("fetch_repo")
+	if err != nil {
+		return "", fmt.Errorf("fetch_repo not found; build it and give its path with -fetch_repo")
+	}
+	return p, nil
+}
+
+// resolveRoots returns the repositories that contain imports, sorted by
+// root import path. Imports under a repository that is already known are
+// not looked up again.
+func (d *depFinder) resolveRoots(imports []string) ([]*vcs.RepoRoot, error) {
+	var roots []*vcs.RepoRoot
+	for _, imp := range imports {
+		found := false
+		for _, r := range roots {
+			if imp == r.Root || strings.HasPrefix(imp, r.Root+"/") {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		r, err := d.repoRoot(imp)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, r)
+	}
+	sort.Sort(byRoot(roots))
+	return roots, nil
+}
+
+// externalImports returns the sorted import paths that the packages in the
+// repository checked out in dir, whose root import path is prefix, import
+// from other repositories, on any platform. Standard library packages and
+// packages vendored in the repository are left out, and so are the imports
+// of tests, since the tests of external repositories are not built.
+func externalImports(dir, prefix string) ([]string, error) {
+	seen := make(map[string]bool)
+	err := packages.WalkAll(build.Default, dir, func(pkg *build.Package) error {
+		rel, err := filepath.Rel(dir, pkg.Dir)
+		if err != nil {
+			return err
+		}
+		for _, elem := range strings.Split(filepath.ToSlash(rel), "/") {
+			if elem == "vendor" {
+				return nil
+			}
+		}
+		for _, imp := range pkg.Imports {
+			if isStandard(imp) || imp == prefix || strings.HasPrefix(imp, prefix+"/") {
+				continue
+			}
+			if isVendored(dir, pkg.Dir, imp) {
+				continue
+			}
+			seen[imp] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var imports []string
+	for imp := range seen {
+		imports = append(imports, imp)
+	}
+	sort.Strings(imports)
+	return imports, nil
+}
+
+// isStandard reports whether imp is the import path of a standard library
+// package or of cgo's pseudo-package "C".
+func isStandard(imp string) bool {
+	first := strings.SplitN(imp, "/", 2)[0]
+	return !strings.Contains(first, ".")
+}
+
+// isVendored reports whether imp is provided by a vendor directory that
+// applies to the package in pkgDir, within the repository in root.
+func isVendored(root, pkgDir, imp string) bool {
+	for d := pkgDir; ; d = filepath.Dir(d) {
+		if fi, err := os.Stat(filepath.Join(d, "vendor", filepath.FromSlash(imp))); err == nil && fi.IsDir() {
+			return true
+		}
+		if d == root || d == filepath.Dir(d) {
+			return false
+		}
+	}
+}
+
+type byRoot []*vcs.RepoRoot
+
+func (s byRoot) Len() int           { return len(s) }
+func (s byRoot) Less(i, j int) bool { return s[i].Root < s[j].Root }
+func (s byRoot) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bzl "github.com/bazelbuild/buildifier/build"
+	"golang.org/x/tools/go/vcs"
+)
+
+// newGoRepo creates a git repository in a new directory under parent with
+// the given files, and returns its directory and commit.
+func newGoRepo(t *testing.T, parent, name string, files map[string]string) (string, string) {
+	dir := filepath.Join(parent, name)
+	for rel, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git(t, dir, "init", "-q")
+	git(t, dir, "add", ".")
+	git(t, dir, "commit", "-q", "-m", "initial")
+	return dir, git(t, dir, "rev-parse", "HEAD")
+}
+
+func TestAddDeps(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	if _, err := fetchRepoTool(); err != nil {
+		t.Skip(err)
+	}
+	tmp, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "deps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// a imports b and, from its vendor directory, v. b imports c, and c
+	// imports a, d, which is already in WORKSPACE, and, on Windows only, e.
+	// a is only in the mirror. The generator in a, which is never built,
+	// imports a repository that does not exist.
+	mirror := filepath.Join(tmp, "mirror")
+	_, aCommit := newGoRepo(t, filepath.Join(mirror, "example.com"), "a", map[string]string{
+		"a.go": `package a
+
+import (
+	"fmt"
+
+	"example.com/a/internal"
+	"example.com/b/pkg"
+	"example.com/v"
+)
+`,
+		"a_test.go":                 `package a; import _ "example.com/t"`,
+		"gen.go":                    "//go:build ignore\n\npackage main\n\nimport _ \"example.com/gen\"\n",
+		"internal/internal.go":      `package internal`,
+		"vendor/example.com/v/v.go": `package v; import _ "example.com/w"`,
+	})
+	bDir, bCommit := newGoRepo(t, tmp, "b", map[string]string{
+		"pkg/b.go": `package b; import _ "example.com/c"`,
+	})
+	cDir, cCommit := newGoRepo(t, tmp, "c", map[string]string{
+		"c.go":         `package c; import (_ "example.com/a"; _ "example.com/d/x")`,
+		"c_windows.go": `package c; import _ "example.com/e"`,
+	})
+	eDir, eCommit := newGoRepo(t, tmp, "e", map[string]string{
+		"e.go": `package e`,
+	})
+	repos := map[string]string{
+		"example.com/a": filepath.Join(tmp, "missing"),
+		"example.com/b": bDir,
+		"example.com/c": cDir,
+		"example.com/d": filepath.Join(tmp, "d"),
+		"example.com/e": eDir,
+	}
+
+	f, err := bzl.Parse("WORKSPACE", []byte(`
+new_go_repository(
+    name = "com_example_d",
+    commit = "0000",
+    importpath = "example.com/d",
+)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	d := newDepFinder(f, &out)
+	d.mirror = mirror
+	d.repoRoot = func(importpath string) (*vcs.RepoRoot, error) {
+		for root, dir := range repos {
+			if importpath == root || strings.HasPrefix(importpath, root+"/") {
+				return &vcs.RepoRoot{VCS: vcs.ByCmd("git"), Repo: dir, Root: root}, nil
+			}
+		}
+		return nil, fmt.Errorf("unexpected import of %s", importpath)
+	}
+	repo := &repository{name: "com_example_a", importpath: "example.com/a", commit: aCommit}
+	r := &vcs.RepoRoot{VCS: vcs.ByCmd("git"), Repo: repos["example.com/a"], Root: "example.com/a"}
+	if err := d.addDeps(repo, r, "  "); err != nil {
+		t.Fatal(err)
+	}
+
+	want := fmt.Sprintf(`  com_example_b: added new_go_repository at commit %s
+    com_example_c: added new_go_repository at commit %s
+      com_example_e: added new_go_repository at commit %s
+`, bCommit, cCommit, eCommit)
+	if got := out.String(); got != want {
+		t.Errorf("addDeps printed:\n%s\nwant:\n%s", got, want)
+	}
+	for name, commit := range map[string]string{
+		"com_example_b": bCommit,
+		"com_example_c": cCommit,
+		"com_example_d": "0000",
+		"com_example_e": eCommit,
+	} {
+		rule := findRepository(f, name, "")
+		if rule == nil {
+			t.Errorf("%s not in WORKSPACE", name)
+		} else if got := rule.AttrString("commit"); got != commit {
+			t.Errorf("%s commit = %q; want %q", name, got, commit)
+		}
+	}
+	if rule := findRepository(f, "com_example_a", ""); rule != nil {
+		t.Errorf("com_example_a was added to WORKSPACE")
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/rules_go/go/tools/gazelle/rules"
+)
+
+// lockEntry is a revision of an import path recorded in a lock file.
+type lockEntry struct {
+	// path is the import path. It is the root of a repository, unless pkg
+	// is set, in which case it may be any package in the repository.
+	path string
+	pkg  bool
+	// Exactly one of commit and tag is set.
+	commit, tag string
+}
+
+// readLockFile reads the dependencies pinned in a Godeps/Godeps.json,
+// glide.lock, vendor/vendor.json, Gopkg.lock or go.mod file and returns a
+// repository for each of them, sorted by import path. For go.sum, the
+// go.mod file next to it is read, since go.sum records hashes of modules
+// rather than which versions are used.
+//
+// repoRoot returns the root import path of the repository that contains an
+// import path. It is called for formats that pin packages rather than
+// repositories, and for modules pinned to a version, whose tag depends on
+// where the module is in its repository.
+func readLockFile(path string, repoRoot func(importpath string) (string, error)) ([]*repository, error) {
+	base := filepath.Base(path)
+	if base == "go.sum" {
+		path = filepath.Join(filepath.Dir(path), "go.mod")
+		base = "go.mod"
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []lockEntry
+	switch base {
+	case "Godeps.json":
+		entries, err = parseGodeps(data)
+	case "glide.lock":
+		entries, err = parseGlideLock(data)
+	case "vendor.json":
+		entries, err = parseVendorJSON(data)
+	case "Gopkg.lock":
+		entries, err = parseGopkgLock(data)
+	case "go.mod":
+		entries, err = parseGoMod(data, repoRoot)
+	default:
+		return nil, fmt.Errorf("%s: unknown lock file format", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	repos, err := pinnedRepositories(entries, repoRoot)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return repos, nil
+}
+
+// pinnedRepositories groups entries by repository. Packages of the same
+// repository must be pinned to the same revision.
+func pinnedRepositories(entries []lockEntry, repoRoot func(string) (string, error)) ([]*repository, error) {
+	byPath := make(map[string]*repository)
+	var roots []string
+	for _, p := range entries {
+		root := p.path
+		if p.pkg {
+			root = ""
+			for _, r := range roots {
+				if p.path == r || strings.HasPrefix(p.path, r+"/") {
+					root = r
+					break
+				}
+			}
+			if root == "" {
+				var err error
+				if root, err = repoRoot(p.path); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if r, ok := byPath[root]; ok {
+			if r.commit != p.commit || r.tag != p.tag {
+				_, old := r.revision()
+				_, rev := (&repository{commit: p.commit, tag: p.tag}).revision()
+				return nil, fmt.Errorf("%s is pinned to both %s and %s", root, old, rev)
+			}
+			continue
+		}
+		byPath[root] = &repository{
+			name:       rules.ImportPathToBazelRepoName(root),
+			importpath: root,
+			commit:     p.commit,
+			tag:        p.tag,
+		}
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	var repos []*repository
+	for _, root := range roots {
+		repos = append(repos, byPath[root])
+	}
+	return repos, nil
+}
+
+// parseGodeps parses a Godeps.json file written by godep.
+func parseGodeps(data []byte) ([]lockEntry, error) {
+	var godeps struct {
+		Deps []struct {
+			ImportPath string
+			Rev        string
+		}
+	}
+	if err := json.Unmarshal(data, &godeps); err != nil {
+		return nil, err
+	}
+	var entries []lockEntry
+	for _, d := range godeps.Deps {
+		entries = append(entries, lockEntry{path: d.ImportPath, pkg: true, commit: d.Rev})
+	}
+	return entries, nil
+}
+
+// parseVendorJSON parses a vendor.json file written by govendor.
+func parseVendorJSON(data []byte) ([]lockEntry, error) {
+	var vendor struct {
+		Package []struct {
+			Path     string `json:"path"`
+			Revision string `json:"revision"`
+		} `json:"package"`
+	}
+	if err := json.Unmarshal(data, &vendor); err != nil {
+		return nil, err
+	}
+	var entries []lockEntry
+	for _, p := range vendor.Package {
+		entries = append(entries, lockEntry{path: p.Path, pkg: true, commit: p.Revision})
+	}
+	return entries, nil
+}
+
+// parseGlideLock parses the imports and testImports of a glide.lock file.
+// Only the subset of YAML that glide writes is understood.
+func parseGlideLock(data []byte) ([]lockEntry, error) {
+	var entries []lockEntry
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if !strings.HasPrefix(line, "- ") && !strings.HasPrefix(line, "  ") {
+			// A top-level key, or the start of the next one.
+			continue
+		}
+		key, value := splitYAML(strings.TrimPrefix(line, "- "))
+		switch {
+		case strings.HasPrefix(line, "- ") && key == "name":
+			entries = append(entries, lockEntry{path: value})
+		case key == "version" && strings.HasPrefix(line, "  ") && !strings.HasPrefix(line, "   "):
+			if len(entries) == 0 {
+				return nil, fmt.Errorf("line %d: version outside of an import", n)
+			}
+			entries[len(entries)-1].commit = value
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	for _, p := range entries {
+		if p.commit == "" {
+			return nil, fmt.Errorf("%s has no version", p.path)
+		}
+	}
+	return entries, nil
+}
+
+// splitYAML splits a YAML mapping entry into its key and unquoted value.
+func splitYAML(line string) (key, value string) {
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return "", ""
+	}
+	key = strings.TrimSpace(line[:i])
+	value = strings.TrimSpace(line[i+1:])
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		value = value[1 : len(value)-1]
+	}
+	return key, value
+}
+
+// parseGopkgLock parses the projects of a Gopkg.lock file written by dep.
+// Only the subset of TOML that dep writes is understood. Projects are
+// pinned to their revision, which dep always records, even for tags.
+func parseGopkgLock(data []byte) ([]lockEntry, error) {
+	var entries []lockEntry
+	inProject := false
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			inProject = line == "[[projects]]"
+			if inProject {
+				entries = append(entries, lockEntry{})
+			}
+			continue
+		}
+		if !inProject {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		key := strings.TrimSpace(line[:i])
+		if key != "name" && key != "revision" {
+			continue
+		}
+		value, err := strconv.Unquote(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s is not a string", n, key)
+		}
+		if key == "name" {
+			entries[len(entries)-1].path = value
+		} else {
+			entries[len(entries)-1].commit = value
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	for _, p := range entries {
+		if p.path == "" || p.commit == "" {
+			return nil, fmt.Errorf("project %q needs both name and revision", p.path)
+		}
+	}
+	return entries, nil
+}
+
+// pseudoVersion matches module pseudo-versions and captures the abbreviated
+// commit they stand for.
+var pseudoVersion = regexp.MustCompile(`[-.][0-9]{14}-([0-9a-f]{12})(\+incompatible)?$`)
+
+// majorVersion matches the last element of a module path with a major
+// version suffix, such as "v2".
+var majorVersion = regexp.MustCompile(`^v[0-9]+$`)
+
+// parseGoMod parses the requirements of a go.mod file. Pseudo-versions are
+// pinned to their commit, and other versions to the tag of the same name,
+// prefixed with the module's directory if it is not at the root of its
+// repository. Modules with a major version suffix are skipped with a
+// warning, since their import path and tags depend on whether the suffix is
+// a directory or a branch. Replacements are not applied.
+func parseGoMod(data []byte, repoRoot func(string) (string, error)) ([]lockEntry, error) {
+	var entries []lockEntry
+	block := ""
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if block != "" {
+			if fields[0] == ")" {
+				block = ""
+				continue
+			}
+			fields = append([]string{block}, fields...)
+		} else if len(fields) == 2 && fields[1] == "(" {
+			block = fields[0]
+			continue
+		}
+
+		switch fields[0] {
+		case "require":
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("line %d: expected module path and version", n)
+			}
+			path, err := unquoteModule(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			if majorVersion.MatchString(path[strings.LastIndex(path, "/")+1:]) {
+				log.Printf("warning: line %d: %s: modules with a major version suffix are not supported", n, path)
+				continue
+			}
+			p := lockEntry{path: path}
+			if m := pseudoVersion.FindStringSubmatch(fields[2]); m != nil {
+				p.commit = m[1]
+				entries = append(entries, p)
+				continue
+			}
+			root, err := repoRoot(path)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			p.tag = strings.TrimSuffix(fields[2], "+incompatible")
+			if root != path {
+				if !strings.HasPrefix(path, root+"/") {
+					return nil, fmt.Errorf("line %d: %s is not in repository %s", n, path, root)
+				}
+				p.tag = path[len(root)+1:] + "/" + p.tag
+			}
+			entries = append(entries, p)
+		case "replace":
+			log.Printf("warning: line %d: replace directives are not applied", n)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func unquoteModule(s string) (string, error) {
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "`") {
+		return strconv.Unquote(s)
+	}
+	return s, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadLockFile(t *testing.T) {
+	for _, c := range []struct {
+		file, content string
+		want          []repository
+	}{
+		{
+			file: "Godeps.json",
+			content: `{
+	"ImportPath": "example.com/project",
+	"Deps": [
+		{"ImportPath": "github.com/golang/glog", "Rev": "23def4e6c14b4da8ac2ed8007337bc5eb5007998"},
+		{"ImportPath": "golang.org/x/net/context", "Rev": "1111111111111111111111111111111111111111"},
+		{"ImportPath": "golang.org/x/net/http2", "Rev": "1111111111111111111111111111111111111111"}
+	]
+}`,
+			want: []repository{
+				{name: "com_github_golang_glog", importpath: "github.com/golang/glog", commit: "23def4e6c14b4da8ac2ed8007337bc5eb5007998"},
+				{name: "org_golang_x_net", importpath: "golang.org/x/net", commit: "1111111111111111111111111111111111111111"},
+			},
+		}, {
+			file: "vendor.json",
+			content: `{
+	"comment": "",
+	"package": [
+		{"checksumSHA1": "x", "path": "golang.org/x/net/context", "revision": "2222", "revisionTime": "2017-01-01T00:00:00Z"},
+		{"checksumSHA1": "y", "path": "github.com/pkg/errors", "revision": "3333"}
+	],
+	"rootPath": "example.com/project"
+}`,
+			want: []repository{
+				{name: "com_github_pkg_errors", importpath: "github.com/pkg/errors", commit: "3333"},
+				{name: "org_golang_x_net", importpath: "golang.org/x/net", commit: "2222"},
+			},
+		}, {
+			file: "glide.lock",
+			content: `hash: 0123456789abcdef
+updated: 2017-01-01T00:00:00Z
+imports:
+- name: github.com/golang/protobuf
+  version: 8ee79997227bf9b34611aee7946ae64735e6fd93
+  subpackages:
+  - proto
+- name: golang.org/x/net
+  version: "4444"
+testImports:
+- name: github.com/stretchr/testify
+  version: 5555
+  repo: https://github.com/stretchr/testify
+`,
+			want: []repository{
+				{name: "com_github_golang_protobuf", importpath: "github.com/golang/protobuf", commit: "8ee79997227bf9b34611aee7946ae64735e6fd93"},
+				{name: "com_github_stretchr_testify", importpath: "github.com/stretchr/testify", commit: "5555"},
+				{name: "org_golang_x_net", importpath: "golang.org/x/net", commit: "4444"},
+			},
+		}, {
+			file: "Gopkg.lock",
+			content: `# This file is autogenerated, do not edit; changes may be undone by the next 'dep ensure'.
+
+
+[[projects]]
+  name = "github.com/pkg/errors"
+  packages = ["."]
+  revision = "645ef00459ed84a119197bfb8d8205042c6df63d"
+  version = "v0.8.0"
+
+[[projects]]
+  branch = "master"
+  name = "golang.org/x/net"
+  packages = ["context"]
+  revision = "6666"
+
+[solve-meta]
+  analyzer-name = "dep"
+  inputs-digest = "abcdef"
+`,
+			want: []repository{
+				{name: "com_github_pkg_errors", importpath: "github.com/pkg/errors", commit: "645ef00459ed84a119197bfb8d8205042c6df63d"},
+				{name: "org_golang_x_net", importpath: "golang.org/x/net", commit: "6666"},
+			},
+		}, {
+			file: "go.mod",
+			content: `module example.com/project
+
+require github.com/pkg/errors v0.8.0
+
+require (
+	golang.org/x/net v0.0.0-20171212005608-d866cfc389ce // indirect
+	"gopkg.in/yaml.v2" v2.0.0+incompatible
+	github.com/example/pre v1.2.4-0.20170101000000-0123456789ab
+	github.com/example/mono/storage v1.2.3
+	github.com/example/major/v2 v2.1.0
+	github.com/example/major/v3 v3.0.0-20170101000000-0123456789ab
+)
+
+replace github.com/pkg/errors => ../errors
+`,
+			want: []repository{
+				{name: "com_github_example_mono_storage", importpath: "github.com/example/mono/storage", tag: "storage/v1.2.3"},
+				{name: "com_github_example_pre", importpath: "github.com/example/pre", commit: "0123456789ab"},
+				{name: "com_github_pkg_errors", importpath: "github.com/pkg/errors", tag: "v0.8.0"},
+				{name: "org_golang_x_net", importpath: "golang.org/x/net", commit: "d866cfc389ce"},
+				{name: "in_gopkg_yaml_v2", importpath: "gopkg.in/yaml.v2", tag: "v2.0.0"},
+			},
+		},
+	} {
+		dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "lockfile")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, c.file)
+		if err := ioutil.WriteFile(path, []byte(c.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		repos, err := readLockFile(path, testRepoRoot)
+		if err != nil {
+			t.Errorf("readLockFile(%q): %v", c.file, err)
+			continue
+		}
+		var got []repository
+		for _, r := range repos {
+			got = append(got, *r)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("readLockFile(%q) = %+v; want %+v", c.file, got, c.want)
+		}
+	}
+}
+
+func TestReadLockFileGoSum(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "lockfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module m\n\nrequire github.com/pkg/errors v0.8.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	repos, err := readLockFile(filepath.Join(dir, "go.sum"), testRepoRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 1 || repos[0].importpath != "github.com/pkg/errors" || repos[0].tag != "v0.8.0" {
+		t.Errorf("readLockFile(go.sum) = %+v; want github.com/pkg/errors at v0.8.0", repos)
+	}
+}
+
+func TestPinnedRepositoriesConflict(t *testing.T) {
+	entries := []lockEntry{
+		{path: "golang.org/x/net/context", pkg: true, commit: "1111"},
+		{path: "golang.org/x/net/http2", pkg: true, commit: "2222"},
+	}
+	_, err := pinnedRepositories(entries, testRepoRoot)
+	if err == nil || !strings.Contains(err.Error(), "pinned to both 1111 and 2222") {
+		t.Errorf("pinnedRepositories with conflicting entries: got error %v", err)
+	}
+}
+
+// testRepoRoot treats the first three elements of an import path as the
+// root of its repository, or the first two for gopkg.in.
+func testRepoRoot(importpath string) (string, error) {
+	parts := strings.Split(importpath, "/")
+	if parts[0] == "gopkg.in" && len(parts) >= 2 {
+		return strings.Join(parts[:2], "/"), nil
+	}
+	if len(parts) < 3 {
+		return "", fmt.Errorf("%s: no repository", importpath)
+	}
+	return strings.Join(parts[:3], "/"), nil
+}
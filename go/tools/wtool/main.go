@@ -6,17 +6,62 @@ Example Usage:
 will add 2 new_go_repository to your WORKSPACE
 by converting com_github_golang_glog -> github.com/golang/glog
 and so forth and then doing a 'git ls-remote' to get
-the latest commit.
+the latest commit. Since underscores in a name may stand for slashes, dots,
+dashes or underscores, every import path that the name could stand for is
+tried, and the name must match exactly one existing repository. Mercurial,
+Bazaar and Subversion repositories are looked up with their own tools.
+If a go_repository or new_go_repository with the same name or importpath
+is already in WORKSPACE, its commit is updated in place instead.
+
+A branch, tag or commit can be given after an @, as in
+  wtool com_github_golang_glog@mybranch
+With -tag, a tag given this way is recorded as the tag attribute instead of
+being resolved to a commit.
 
 If wtool cannot figure out the bazel -> Go mapping, try
 Other Usage:
   wtool -asis github.com/golang/glog
 which takes an importpath, and computes the bazel name + ls-remote as above.
+Alternatively, -map names a file with lines such as
+  com_github_foo_bar_baz github.com/foo_bar/baz
+  org_golang_google google.golang.org
+which give the import path of a name, or of the names that start with it.
+
+To pin the tags of go_repository entries to the commits they were
+fetched at,
+  wtool -pin_tags $(bazel info output_base)/external
+which reads the metadata files that fetch_repo leaves in each repository.
+
+To add or update the repositories pinned by the lock file of another
+dependency management tool,
+  wtool -import_from Gopkg.lock
+which understands Godeps/Godeps.json, glide.lock, vendor/vendor.json,
+Gopkg.lock and go.mod (or go.sum, whose go.mod is read instead).
+
+To see which repositories in WORKSPACE are behind upstream,
+  wtool -outdated
+prints each one with the revision it is pinned to, the latest commit and,
+for git repositories, how many commits it is ahead and behind. With
+-upgrade, the outdated ones are also pinned to the latest commit.
+
+To find the Go repositories in WORKSPACE that nothing depends on,
+  wtool -unused -external $(bazel info output_base)/external
+searches the BUILD, .bzl and WORKSPACE files of the workspace, and of the
+fetched repositories that are in use, for labels such as @name//pkg:target.
+With -prune, the unused repositories are also removed; -external is then
+required, since without it the repositories that only other repositories
+depend on would be removed too. Rules with a "# keep" comment before them
+or on their name are never reported.
+
+With -deps, wtool also checks out each repository it is given, finds the
+repositories its Go packages import from on any platform, and adds those
+that are missing from WORKSPACE, recursively. The repositories it adds are
+printed as a tree. Repositories are checked out with fetch_repo, which uses
+GO_REPOSITORY_MIRROR and GO_REPOSITORY_CACHE as go_repository does.
 */
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -24,6 +69,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	bzl "github.com/bazelbuild/buildifier/build"
@@ -33,13 +79,22 @@ import (
 )
 
 var (
-	asis    = flag.Bool("asis", false, "if true, leave the import names as-is (by default they are treated as bazel converted names like org_golang_x_net")
-	verbose = flag.Bool("verbose", false, "if true, logging extra information")
+	asis     = flag.Bool("asis", false, "if true, leave the import names as-is (by default they are treated as bazel converted names like org_golang_x_net")
+	verbose  = flag.Bool("verbose", false, "if true, logging extra information")
+	useTag   = flag.Bool("tag", false, "if true, record refs given as name@tag as the tag attribute rather than resolving them to commits")
+	pin      = flag.String("pin_tags", "", "directory of fetched external repositories; if set, replaces the tag of each go_repository in WORKSPACE with the commit it was fetched at")
+	lock     = flag.String("import_from", "", "Godeps.json, glide.lock, vendor.json, Gopkg.lock or go.mod file whose pinned dependencies are added to or updated in WORKSPACE")
+	outdated = flag.Bool("outdated", false, "if true, report which repositories in WORKSPACE are not at the latest upstream commit")
+	upgrade  = flag.Bool("upgrade", false, "with -outdated, pin outdated repositories to the latest upstream commit")
+	unused   = flag.Bool("unused", false, "if true, report the Go repositories in WORKSPACE that no BUILD, .bzl or WORKSPACE file refers to")
+	prune    = flag.Bool("prune", false, "if true, remove the Go repositories that -unused would report; requires -external")
+	external = flag.String("external", "", "directory of fetched external repositories; with -unused or -prune, the files of used repositories there are searched too")
+	deps     = flag.Bool("deps", false, "if true, also add the repositories that the given repositories depend on, recursively")
+	fetchBin = flag.String("fetch_repo", "", "path of the fetch_repo binary that -deps checks out repositories with; by default, the one built with wtool or the one in PATH")
+	mapFile  = flag.String("map", "", "file that maps repository names, or prefixes of them, to import paths")
 
-	knownPaths = map[string]string{
-		"org_golang_google": "google.golang.org/",
-		"com_google_cloud":  "cloud.google.com/",
-	}
+	// mapping is read from -map.
+	mapping map[string]string
 )
 
 func main() {
@@ -50,6 +105,9 @@ func main() {
 }
 
 func run(args []string) error {
+	if *prune && *external == "" {
+		return fmt.Errorf("-prune requires -external, so that the repositories that other repositories depend on are kept")
+	}
 	cwd, err := os.Getwd()
 	if err != nil {
 		return err
@@ -67,15 +125,90 @@ func run(args []string) error {
 	if err != nil {
 		return err
 	}
+	if *mapFile != "" {
+		if mapping, err = readMapping(*mapFile); err != nil {
+			return err
+		}
+	}
+	// modified is set by the modes that change WORKSPACE, so that reports
+	// such as -unused leave the file alone.
+	modified := false
+	if *pin != "" {
+		changes, err := pinTags(f, *pin)
+		if err != nil {
+			return err
+		}
+		for _, c := range changes {
+			fmt.Println(c)
+		}
+		modified = true
+	}
+	if *lock != "" {
+		repos, err := readLockFile(*lock, func(importpath string) (string, error) {
+			r, err := vcs.RepoRootForImportPath(importpath, *verbose)
+			if err != nil {
+				return "", err
+			}
+			return r.Root, nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, repo := range repos {
+			fmt.Println(updateRepository(f, repo))
+		}
+		modified = true
+	}
+	if *outdated {
+		statuses := checkOutdated(f, func(importpath string) (*vcs.RepoRoot, error) {
+			return vcs.RepoRootForImportPath(importpath, *verbose)
+		})
+		if err := printOutdated(os.Stdout, statuses); err != nil {
+			return err
+		}
+		if *upgrade {
+			for _, c := range upgradeOutdated(statuses) {
+				fmt.Println(c)
+			}
+			modified = true
+		}
+	}
+	if *unused || *prune {
+		unusedRules, err := unusedRepositories(f, w, *external)
+		if err != nil {
+			return err
+		}
+		for _, r := range unusedRules {
+			if *prune {
+				fmt.Printf("%s: removed unused %s\n", r.Name(), r.Kind())
+			} else {
+				fmt.Printf("%s: unused\n", r.Name())
+			}
+		}
+		if *prune {
+			pruneRepositories(f, unusedRules)
+			modified = true
+		}
+	}
+	d := newDepFinder(f, os.Stdout)
 	for _, arg := range args {
-		imp, err := findImport(arg)
+		repo, r, err := findImport(arg)
 		if err != nil {
 			return err
 		}
-		// TODO(pmbethe09): ignore or maybe update sha1 if already defined in workspace.
-		f.Stmt = append(f.Stmt, imp)
+		fmt.Println(updateRepository(f, repo))
+		if *deps {
+			if err := d.addDeps(repo, r, "  "); err != nil {
+				return err
+			}
+		}
+		modified = true
+	}
+	if !modified {
+		return nil
 	}
-	bzl.Rewrite(f, nil)
+	// The file is not rewritten, so that rules that were not touched keep
+	// the order of their attributes.
 	return ioutil.WriteFile(f.Path, bzl.Format(f), 0644)
 }
 
@@ -83,47 +216,45 @@ func nameAndImportpath(name string) (string, string, error) {
 	if *asis {
 		return rules.ImportPathToBazelRepoName(name), name, nil
 	}
-	s := strings.Split(name, "_")
-	if len(s) < 4 {
-		return "", "", fmt.Errorf("workspace names must be 4-parts or longer: %q", name)
+	importpath, err := resolveName(name, mapping, verifyImportpath)
+	if err != nil {
+		return "", "", err
 	}
-	rest := strings.Join(s[3:], "-")
-	for k, v := range knownPaths {
-		if strings.HasPrefix(name, k) {
-			return name, v + rest, nil
-		}
+	return name, importpath, nil
+}
+
+// splitRef splits an argument of the form name@ref.
+func splitRef(arg string) (name, ref string) {
+	if i := strings.LastIndex(arg, "@"); i >= 0 {
+		return arg[:i], arg[i+1:]
 	}
-	return name, strings.Join([]string{s[1] + "." + s[0], s[2], rest}, "/"), nil
+	return arg, ""
 }
 
-func findImport(nameIn string) (bzl.Expr, error) {
+func findImport(arg string) (*repository, *vcs.RepoRoot, error) {
+	nameIn, ref := splitRef(arg)
 	name, importpath, err := nameAndImportpath(nameIn)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if *verbose {
-		log.Printf(importpath)
+		log.Print(importpath)
 	}
 	r, err := vcs.RepoRootForImportPath(importpath, false)
 	if err != nil {
-		return nil, err
-	}
-	if r.VCS.Cmd != "git" {
-		return nil, fmt.Errorf("only git supported, not %q", r.VCS.Cmd)
+		return nil, nil, err
 	}
-	// TODO(pmbethe09): allow ref to be provided, e.g. com_github_golang_glog:mybranch
-	commit, err := lsRemote(r.Repo)
+	commit, isTag, err := latestRevision(r.VCS, r.Repo, ref)
 	if err != nil {
-		return nil, err
-	}
-	return &bzl.CallExpr{
-		X: &bzl.LiteralExpr{Token: "new_go_repository"},
-		List: []bzl.Expr{
-			attr("name", name),
-			attr("importpath", importpath),
-			attr("commit", commit),
-		},
-	}, nil
+		return nil, nil, err
+	}
+	if *useTag && ref != "" {
+		if !isTag {
+			return nil, nil, fmt.Errorf("%s: %q is not a tag", importpath, ref)
+		}
+		return &repository{name: name, importpath: importpath, tag: ref}, r, nil
+	}
+	return &repository{name: name, importpath: importpath, commit: commit}, r, nil
 }
 
 func attr(key, val string) *bzl.BinaryExpr {
@@ -134,25 +265,54 @@ func attr(key, val string) *bzl.BinaryExpr {
 	}
 }
 
-func lsRemote(repo string) (string, error) {
-	cmd := exec.Command("git", "ls-remote", repo, "HEAD")
-	r, err := cmd.StdoutPipe()
+// commitPattern matches strings that look like abbreviated or full git
+// commit hashes.
+var commitPattern = regexp.MustCompile("^[0-9a-f]{7,40}$")
+
+// lsRemote resolves ref in the git repository repo to a commit and reports
+// whether ref is a tag. ref may be a branch, a tag, a full ref name, or a
+// commit, which is returned as is. An empty ref stands for HEAD.
+func lsRemote(repo, ref string) (commit string, isTag bool, err error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	// Peeled tags only match patterns that end in ^{}.
+	cmd := exec.Command("git", "ls-remote", repo, ref, ref+"^{}")
+	// Fail rather than ask for credentials for repositories that do not
+	// exist, which is how some hosts answer.
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	out, err := cmd.Output()
 	if err != nil {
-		return "", err
+		return "", false, fmt.Errorf("git ls-remote %s %s: %v", repo, ref, err)
 	}
-	if err := cmd.Start(); err != nil {
-		return "", err
+	refs := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		if *verbose && line != "" {
+			log.Print(line)
+		}
+		if fields := strings.Fields(line); len(fields) == 2 {
+			refs[fields[1]] = fields[0]
+		}
 	}
-	b := bufio.NewScanner(r)
-	if !b.Scan() {
-		if err := b.Err(); err != nil {
-			return "", err
+
+	// Annotated tags are listed twice; the ^{} entry is the commit they
+	// point to. Tags take precedence over branches, as in git.
+	for _, c := range []struct {
+		name  string
+		isTag bool
+	}{
+		{"refs/tags/" + ref + "^{}", true},
+		{"refs/tags/" + ref, true},
+		{"refs/heads/" + ref, false},
+		{ref + "^{}", strings.HasPrefix(ref, "refs/tags/")},
+		{ref, strings.HasPrefix(ref, "refs/tags/")},
+	} {
+		if commit, ok := refs[c.name]; ok {
+			return commit, c.isTag, nil
 		}
-		return "", fmt.Errorf("nothing returned from ls-remote %q", repo)
 	}
-	if *verbose {
-		log.Printf(b.Text())
+	if commitPattern.MatchString(ref) {
+		return ref, false, nil
 	}
-	go cmd.Wait()
-	return strings.Split(b.Text(), "\t")[0], nil
+	return "", false, fmt.Errorf("%s: no branch, tag or commit named %q", repo, ref)
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// git runs git in dir and returns its trimmed output.
+func git(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commitFile writes a file in the git repository dir, commits it and
+// returns the new commit.
+func commitFile(t *testing.T, dir, name, content string) string {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, dir, "add", name)
+	git(t, dir, "commit", "-q", "-m", "change "+name)
+	return git(t, dir, "rev-parse", "HEAD")
+}
+
+func TestSplitRef(t *testing.T) {
+	for _, c := range []struct {
+		arg, name, ref string
+	}{
+		{"com_github_golang_glog", "com_github_golang_glog", ""},
+		{"com_github_golang_glog@mybranch", "com_github_golang_glog", "mybranch"},
+		{"github.com/golang/glog@v1.0", "github.com/golang/glog", "v1.0"},
+	} {
+		if name, ref := splitRef(c.arg); name != c.name || ref != c.ref {
+			t.Errorf("splitRef(%q) = %q, %q; want %q, %q", c.arg, name, ref, c.name, c.ref)
+		}
+	}
+}
+
+func TestLsRemote(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	repo, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repo)
+	git(t, repo, "init", "-q")
+	first := commitFile(t, repo, "a.go", "package a")
+	git(t, repo, "tag", "v1")
+	git(t, repo, "tag", "-a", "-m", "annotated", "v1.1")
+	git(t, repo, "checkout", "-q", "-b", "feature")
+	feature := commitFile(t, repo, "b.go", "package a")
+	git(t, repo, "checkout", "-q", "-")
+	head := commitFile(t, repo, "c.go", "package a")
+
+	for _, c := range []struct {
+		ref    string
+		commit string
+		isTag  bool
+	}{
+		{"", head, false},
+		{"feature", feature, false},
+		{"v1", first, true},
+		{"v1.1", first, true},
+		{"refs/tags/v1.1", first, true},
+		{"refs/heads/feature", feature, false},
+		{first[:12], first[:12], false},
+	} {
+		commit, isTag, err := lsRemote(repo, c.ref)
+		if err != nil {
+			t.Errorf("lsRemote(%q) failed with %v; want success", c.ref, err)
+			continue
+		}
+		if commit != c.commit || isTag != c.isTag {
+			t.Errorf("lsRemote(%q) = %q, %v; want %q, %v", c.ref, commit, isTag, c.commit, c.isTag)
+		}
+	}
+	if _, _, err := lsRemote(repo, "missing"); err == nil {
+		t.Errorf("lsRemote(%q) succeeded; want error", "missing")
+	}
+}
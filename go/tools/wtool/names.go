@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// maxCandidates bounds the number of import paths that are checked for a
+// repository name, since each check may go over the network.
+const maxCandidates = 1024
+
+// readMapping reads a file that maps repository names to import paths. Each
+// line holds a name and an import path separated by white space; blank lines
+// and lines starting with # are ignored. A name also applies to the longer
+// names that start with it followed by an underscore, so that the line
+// "org_golang_google google.golang.org" says that org_golang_google_api is
+// google.golang.org/api or another import path under google.golang.org.
+func readMapping(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	m := make(map[string]string)
+	s := bufio.NewScanner(file)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a repository name and an import path", path, n)
+		}
+		m[fields[0]] = fields[1]
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// candidateImportpaths returns the import paths that
+// rules.ImportPathToBazelRepoName converts to name, leaving out host names
+// that contain dashes. If a prefix of name is in mapping, only the import
+// paths under the mapped one are returned. If name itself is in mapping, the
+// mapped import path is the only candidate, and exact is set.
+func candidateImportpaths(name string, mapping map[string]string) (candidates []string, exact bool, err error) {
+	if p, ok := mapping[name]; ok {
+		return []string{p}, true, nil
+	}
+	var prefix string
+	for k := range mapping {
+		if strings.HasPrefix(name, k+"_") && len(k) > len(prefix) {
+			prefix = k
+		}
+	}
+
+	add := func(base string, rest []string) error {
+		n := 1
+		for range rest[1:] {
+			if n *= 4; len(candidates)+n > maxCandidates {
+				return fmt.Errorf("%s has too many possible import paths; use -asis or add it to a -map file", name)
+			}
+		}
+		candidates = append(candidates, joinings(base, rest)...)
+		return nil
+	}
+	if prefix != "" {
+		rest := strings.Split(strings.TrimPrefix(name, prefix+"_"), "_")
+		if err := add(mapping[prefix], rest); err != nil {
+			return nil, false, err
+		}
+		return candidates, false, nil
+	}
+
+	parts := strings.Split(name, "_")
+	if len(parts) < 3 {
+		return nil, false, fmt.Errorf("workspace names must be 3-parts or longer: %q", name)
+	}
+	// The host name takes at least two parts, and the path at least one.
+	for k := 2; k < len(parts); k++ {
+		host := make([]string, k)
+		for i := range host {
+			host[i] = parts[k-1-i]
+		}
+		if err := add(strings.Join(host, "."), parts[k:]); err != nil {
+			return nil, false, err
+		}
+	}
+	return candidates, false, nil
+}
+
+// joinings returns the paths formed by appending the parts to base, the
+// first after a slash and each of the others after a slash, dash,
+// underscore or dot.
+func joinings(base string, parts []string) []string {
+	paths := []string{base + "/" + parts[0]}
+	for _, part := range parts[1:] {
+		var next []string
+		for _, p := range paths {
+			for _, sep := range []string{"/", "-", "_", "."} {
+				next = append(next, p+sep+part)
+			}
+		}
+		paths = next
+	}
+	return paths
+}
+
+// resolveName returns the import path of the repository that name stands
+// for. Each candidate import path is checked with verify, and exactly one
+// must pass. An import path that name is mapped to exactly is not checked.
+func resolveName(name string, mapping map[string]string, verify func(importpath string) error) (string, error) {
+	candidates, exact, err := candidateImportpaths(name, mapping)
+	if err != nil {
+		return "", err
+	}
+	if exact {
+		return candidates[0], nil
+	}
+	var found []string
+	for _, c := range candidates {
+		err := verify(c)
+		if *verbose {
+			log.Printf("%s: %v", c, err)
+		}
+		if err == nil {
+			found = append(found, c)
+		}
+	}
+	sort.Strings(found)
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no repository found for %s; tried:\n\t%s", name, strings.Join(candidates, "\n\t"))
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("%s is ambiguous; it could be any of:\n\t%s\nuse -asis with one of them, or add it to a -map file", name, strings.Join(found, "\n\t"))
+	}
+}
+
+// verifyImportpath checks that importpath is the root of a repository that
+// exists.
+func verifyImportpath(importpath string) error {
+	r, err := vcs.RepoRootForImportPath(importpath, false)
+	if err != nil {
+		return err
+	}
+	if r.Root != importpath {
+		return fmt.Errorf("%s is in repository %s", importpath, r.Root)
+	}
+	_, _, err = latestRevision(r.VCS, r.Repo, "")
+	return err
+}
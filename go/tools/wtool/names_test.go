@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCandidateImportpaths(t *testing.T) {
+	got, exact, err := candidateImportpaths("com_github_foo_bar", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"github.com/foo/bar",
+		"github.com/foo-bar",
+		"github.com/foo_bar",
+		"github.com/foo.bar",
+		"foo.github.com/bar",
+	}
+	if !reflect.DeepEqual(got, want) || exact {
+		t.Errorf("candidateImportpaths(com_github_foo_bar) = %q, %v; want %q, false", got, exact, want)
+	}
+
+	if _, _, err := candidateImportpaths("com_github", nil); err == nil {
+		t.Errorf("candidateImportpaths(com_github) succeeded; want error")
+	}
+	long := "com_example_" + strings.Repeat("a_", 10) + "b"
+	if _, _, err := candidateImportpaths(long, nil); err == nil || !strings.Contains(err.Error(), "too many") {
+		t.Errorf("candidateImportpaths(%s): got error %v; want too many import paths", long, err)
+	}
+}
+
+func TestResolveName(t *testing.T) {
+	exists := map[string]bool{
+		"github.com/foo/bar_baz":     true,
+		"github.com/foo_bar/baz":     true,
+		"github.com/golang/glog":     true,
+		"cloud.google.com/go":        true,
+		"google.golang.org/api":      true,
+		"google.golang.org/api/foo":  true,
+		"example.com/elsewhere/glog": true,
+	}
+	verify := func(importpath string) error {
+		if !exists[importpath] {
+			return fmt.Errorf("%s not found", importpath)
+		}
+		return nil
+	}
+	mapping := map[string]string{
+		"org_golang_google":      "google.golang.org",
+		"org_golang_google_api":  "google.golang.org/api",
+		"com_github_golang_glog": "example.com/elsewhere/glog",
+	}
+
+	for _, c := range []struct {
+		name, want, err string
+	}{
+		{name: "com_github_foo_bar_baz", err: "ambiguous; it could be any of:\n\tgithub.com/foo/bar_baz\n\tgithub.com/foo_bar/baz\n"},
+		{name: "com_google_cloud_go", want: "cloud.google.com/go"},
+		{name: "com_github_nothing_here", err: "no repository found for com_github_nothing_here; tried:\n\tgithub.com/nothing/here\n"},
+		{name: "org_golang_google_api_foo", want: "google.golang.org/api/foo"},
+		{name: "com_github_golang_glog", want: "example.com/elsewhere/glog"},
+	} {
+		got, err := resolveName(c.name, mapping, verify)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("resolveName(%q): got error %v; want error containing %q", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("resolveName(%q): %v", c.name, err)
+		} else if got != c.want {
+			t.Errorf("resolveName(%q) = %q; want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestReadMapping(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "mapping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "map")
+	const content = `# Hosts.
+org_golang_google   google.golang.org
+
+com_github_foo_bar_baz github.com/foo_bar/baz
+`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readMapping(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"org_golang_google":      "google.golang.org",
+		"com_github_foo_bar_baz": "github.com/foo_bar/baz",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readMapping = %v; want %v", got, want)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("com_github_foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readMapping(path); err == nil {
+		t.Errorf("readMapping of a line without an import path succeeded; want error")
+	}
+}
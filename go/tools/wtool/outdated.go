@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	bzl "github.com/bazelbuild/buildifier/build"
+	"golang.org/x/tools/go/vcs"
+)
+
+// repoStatus compares the revision a Go repository rule is pinned to with
+// the latest revision upstream.
+type repoStatus struct {
+	rule *bzl.Rule
+	// pinned is the commit or tag in WORKSPACE, and commit is the commit it
+	// stands for.
+	pinned, commit string
+	latest         string
+	// vcs is the command of the version control system of the repository.
+	vcs string
+	// ahead and behind count the commits that only the pinned or only the
+	// latest revision has. They are only known for git repositories, and
+	// are -1 otherwise.
+	ahead, behind int
+	err           error
+}
+
+// upToDate reports whether the pinned revision is the latest one. Pinned git
+// and Mercurial hashes may be abbreviated; Subversion and Bazaar revision
+// numbers must match exactly, since revision 12 is not revision 123.
+func (s *repoStatus) upToDate() bool {
+	if s.commit == "" {
+		return false
+	}
+	switch s.vcs {
+	case "git", "hg":
+		return strings.HasPrefix(s.latest, s.commit)
+	default:
+		return s.latest == s.commit
+	}
+}
+
+func (s *repoStatus) String() string {
+	switch {
+	case s.err != nil:
+		return "error: " + s.err.Error()
+	case s.upToDate():
+		return "up to date"
+	case s.behind < 0:
+		return "outdated"
+	case s.ahead == 0:
+		return fmt.Sprintf("%d behind", s.behind)
+	default:
+		return fmt.Sprintf("%d ahead, %d behind", s.ahead, s.behind)
+	}
+}
+
+// checkOutdated looks up the latest revision of the default branch of each
+// Go repository rule in f that is pinned to a commit or tag. repoRoot
+// resolves the import paths of rules that do not give a vcs and remote.
+// Failures to look up a repository are recorded in its status rather than
+// returned.
+func checkOutdated(f *bzl.File, repoRoot func(importpath string) (*vcs.RepoRoot, error)) []*repoStatus {
+	var statuses []*repoStatus
+	for _, r := range f.Rules("") {
+		if !isRepositoryKind(r.Kind()) {
+			continue
+		}
+		pinned := r.AttrString("commit")
+		if pinned == "" {
+			pinned = r.AttrString("tag")
+		}
+		if pinned == "" {
+			// Rules that fetch archives have nothing to compare.
+			continue
+		}
+		s := &repoStatus{rule: r, pinned: pinned, ahead: -1, behind: -1}
+		s.err = s.check(repoRoot)
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
+
+func (s *repoStatus) check(repoRoot func(string) (*vcs.RepoRoot, error)) error {
+	r, err := ruleRepoRoot(s.rule, repoRoot)
+	if err != nil {
+		return err
+	}
+	s.vcs = r.VCS.Cmd
+	if s.latest, _, err = latestRevision(r.VCS, r.Repo, ""); err != nil {
+		return err
+	}
+	s.commit = s.pinned
+	if tag := s.rule.AttrString("tag"); tag != "" {
+		if s.commit, _, err = latestRevision(r.VCS, r.Repo, tag); err != nil {
+			return err
+		}
+	}
+	if s.upToDate() {
+		s.ahead, s.behind = 0, 0
+		return nil
+	}
+	if r.VCS.Cmd == "git" {
+		s.ahead, s.behind, err = countCommits(r.Repo, s.commit, s.latest)
+	}
+	return err
+}
+
+// ruleRepoRoot returns the repository that a Go repository rule fetches.
+func ruleRepoRoot(r *bzl.Rule, repoRoot func(string) (*vcs.RepoRoot, error)) (*vcs.RepoRoot, error) {
+	importpath := r.AttrString("importpath")
+	remote := r.AttrString("remote")
+	if name := r.AttrString("vcs"); name != "" {
+		v := vcs.ByCmd(name)
+		if v == nil {
+			return nil, fmt.Errorf("unknown version control system %q", name)
+		}
+		root := r.AttrString("root")
+		if root == "" {
+			root = importpath
+		}
+		return &vcs.RepoRoot{VCS: v, Repo: remote, Root: root}, nil
+	}
+	if remote == "" {
+		remote = importpath
+	}
+	return repoRoot(remote)
+}
+
+// countCommits fetches the history of the commits pinned and latest from the
+// git repository repo, without file contents, and counts the commits that are
+// reachable from only one of them. An abbreviated pinned commit can only be
+// found if it is an ancestor of latest.
+func countCommits(repo, pinned, latest string) (ahead, behind int, err error) {
+	tmp, err := ioutil.TempDir("", "wtool")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, "repo.git")
+	if out, err := exec.Command("git", "init", "-q", "--bare", dir).CombinedOutput(); err != nil {
+		return 0, 0, fmt.Errorf("git init: %v\n%s", err, out)
+	}
+	revs := []string{latest}
+	if len(pinned) == 40 {
+		revs = append(revs, pinned)
+	}
+	cmd := exec.Command("git", append([]string{"fetch", "-q", "--filter=blob:none", repo}, revs...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return 0, 0, fmt.Errorf("git fetch %s: %v\n%s", repo, err, out)
+	}
+	cmd = exec.Command("git", "rev-list", "--left-right", "--count", pinned+"..."+latest)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: cannot compare %s with %s: %v", repo, pinned, latest, err)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("git rev-list: unexpected output %q", out)
+	}
+	if ahead, err = strconv.Atoi(fields[0]); err != nil {
+		return 0, 0, err
+	}
+	if behind, err = strconv.Atoi(fields[1]); err != nil {
+		return 0, 0, err
+	}
+	return ahead, behind, nil
+}
+
+// printOutdated writes a table of statuses to w.
+func printOutdated(w io.Writer, statuses []*repoStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tPINNED\tLATEST\tSTATUS")
+	for _, s := range statuses {
+		latest := s.latest
+		if latest == "" {
+			latest = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.rule.Name(), abbrev(s.pinned), abbrev(latest), s)
+	}
+	return tw.Flush()
+}
+
+// abbrev shortens full commit hashes for display.
+func abbrev(rev string) string {
+	if len(rev) > 12 && commitPattern.MatchString(rev) {
+		return rev[:12]
+	}
+	return rev
+}
+
+// upgradeOutdated pins each repository that is not up to date to its latest
+// commit. It returns a description of each change.
+func upgradeOutdated(statuses []*repoStatus) []string {
+	var changes []string
+	for _, s := range statuses {
+		if s.err != nil || s.upToDate() {
+			continue
+		}
+		old := setRevision(s.rule, "commit", s.latest)
+		changes = append(changes, fmt.Sprintf("%s: updated %s to commit %s", s.rule.Name(), old, s.latest))
+	}
+	return changes
+}
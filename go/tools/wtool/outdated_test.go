@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	bzl "github.com/bazelbuild/buildifier/build"
+	"golang.org/x/tools/go/vcs"
+)
+
+func TestOutdated(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	tmp, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "outdated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	current, currentHead := newGoRepo(t, tmp, "current", map[string]string{"a.go": "package a"})
+	behind, first := newGoRepo(t, tmp, "behind", map[string]string{"a.go": "package a"})
+	git(t, behind, "tag", "v1")
+	commitFile(t, behind, "b.go", "package a")
+	behindHead := commitFile(t, behind, "c.go", "package a")
+	diverged, _ := newGoRepo(t, tmp, "diverged", map[string]string{"a.go": "package a"})
+	git(t, diverged, "checkout", "-q", "-b", "fork")
+	fork := commitFile(t, diverged, "fork.go", "package a")
+	git(t, diverged, "checkout", "-q", "-")
+	divergedHead := commitFile(t, diverged, "b.go", "package a")
+
+	f, err := bzl.Parse("WORKSPACE", []byte(fmt.Sprintf(`
+new_go_repository(
+    name = "com_example_current",
+    commit = %[1]q,
+    importpath = "example.com/current",
+    remote = %[2]q,
+    vcs = "git",
+)
+
+new_go_repository(
+    name = "com_example_behind",
+    commit = %[3]q,
+    importpath = "example.com/behind",
+)
+
+go_repository(
+    name = "com_example_tagged",
+    importpath = "example.com/behind",
+    remote = "example.com/behind",
+    tag = "v1",
+)
+
+new_go_repository(
+    name = "com_example_diverged",
+    commit = %[4]q,
+    importpath = "example.com/diverged",
+    remote = %[5]q,
+    vcs = "git",
+)
+
+go_repository(
+    name = "com_example_archive",
+    importpath = "example.com/archive",
+    urls = ["https://example.com/archive.zip"],
+)
+
+go_repository(
+    name = "com_example_missing",
+    commit = "0123456789ab",
+    importpath = "example.com/missing",
+)
+`, currentHead[:7], current, first, fork, diverged)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := checkOutdated(f, func(importpath string) (*vcs.RepoRoot, error) {
+		if importpath == "example.com/behind" {
+			return &vcs.RepoRoot{VCS: vcs.ByCmd("git"), Repo: behind, Root: importpath}, nil
+		}
+		return nil, fmt.Errorf("%s not found", importpath)
+	})
+
+	var out bytes.Buffer
+	if err := printOutdated(&out, statuses); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf(`NAME                  PINNED        LATEST        STATUS
+com_example_current   %-12s  %s  up to date
+com_example_behind    %s  %s  2 behind
+com_example_tagged    v1            %s  2 behind
+com_example_diverged  %s  %s  1 ahead, 1 behind
+com_example_missing   0123456789ab  -             error: example.com/missing not found
+`, currentHead[:7], currentHead[:12],
+		first[:12], behindHead[:12],
+		behindHead[:12],
+		fork[:12], divergedHead[:12])
+	if got := out.String(); got != want {
+		t.Errorf("printOutdated wrote:\n%s\nwant:\n%s", got, want)
+	}
+
+	changes := upgradeOutdated(statuses)
+	wantChanges := []string{
+		fmt.Sprintf("com_example_behind: updated commit %s to commit %s", first, behindHead),
+		fmt.Sprintf("com_example_tagged: updated tag v1 to commit %s", behindHead),
+		fmt.Sprintf("com_example_diverged: updated commit %s to commit %s", fork, divergedHead),
+	}
+	if !reflect.DeepEqual(changes, wantChanges) {
+		t.Errorf("upgradeOutdated changes = %q; want %q", changes, wantChanges)
+	}
+	if got := findRepository(f, "com_example_current", "").AttrString("commit"); got != currentHead[:7] {
+		t.Errorf("com_example_current commit = %q; want it left at %q", got, currentHead[:7])
+	}
+	if ws := string(bzl.Format(f)); !strings.Contains(ws, filepath.Base(diverged)) {
+		t.Errorf("remote of com_example_diverged was lost:\n%s", ws)
+	}
+}
+
+func TestUpToDate(t *testing.T) {
+	for _, c := range []struct {
+		vcs, commit, latest string
+		want                bool
+	}{
+		{"git", "0123456", "0123456789abcdef0123456789abcdef01234567", true},
+		{"git", "0123457", "0123456789abcdef0123456789abcdef01234567", false},
+		{"hg", "0123456789ab", "0123456789abcdef0123456789abcdef01234567", true},
+		{"svn", "12", "123", false},
+		{"svn", "123", "123", true},
+		{"bzr", "1", "10", false},
+		{"git", "", "0123456789abcdef0123456789abcdef01234567", false},
+	} {
+		s := &repoStatus{vcs: c.vcs, commit: c.commit, latest: c.latest}
+		if got := s.upToDate(); got != c.want {
+			t.Errorf("upToDate of %s %q at %q = %v; want %v", c.vcs, c.commit, c.latest, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	bzl "github.com/bazelbuild/buildifier/build"
+	"github.com/bazelbuild/rules_go/go/tools/fetch_repo/metadata"
+)
+
+// pinTags replaces the tag of each go_repository and new_go_repository rule
+// in f with the commit that the tag resolved to when the repository was
+// fetched. external is the directory that holds the fetched repositories,
+// usually $(bazel info output_base)/external, and is searched for the
+// metadata files written by fetch_repo. Rules whose repository has not been
+// fetched are left alone. It returns a description of each change.
+func pinTags(f *bzl.File, external string) ([]string, error) {
+	var changes []string
+	for _, kind := range repositoryKinds {
+		for _, r := range f.Rules(kind) {
+			tag := r.AttrString("tag")
+			if tag == "" {
+				continue
+			}
+			m, err := metadata.Read(filepath.Join(external, r.Name()))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if m.Commit == "" {
+				return nil, fmt.Errorf("%s: no commit recorded for %s", r.Name(), m.Remote)
+			}
+			if m.Rev != tag {
+				return nil, fmt.Errorf("%s: fetched revision %q, but the tag is %q; fetch it again first", r.Name(), m.Rev, tag)
+			}
+			setRevision(r, "commit", m.Commit)
+			changes = append(changes, fmt.Sprintf("%s: pinned tag %s to commit %s", r.Name(), tag, m.Commit))
+		}
+	}
+	return changes, nil
+}
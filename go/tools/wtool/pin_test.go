@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	bzl "github.com/bazelbuild/buildifier/build"
+	"github.com/bazelbuild/rules_go/go/tools/fetch_repo/metadata"
+)
+
+func TestPinTags(t *testing.T) {
+	external, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "external")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(external)
+	for name, m := range map[string]*metadata.Metadata{
+		"com_github_a_tagged": {VCS: "git", Rev: "v1.0.0", Commit: "aaaa"},
+		"com_github_b_new":    {VCS: "git", Rev: "v2", Commit: "bbbb"},
+		"com_github_c_commit": {VCS: "git", Rev: "cccc", Commit: "cccc"},
+	} {
+		dir := filepath.Join(external, name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := metadata.Write(dir, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const workspace = `go_repository(
+    name = "com_github_a_tagged",
+    importpath = "github.com/a/tagged",
+    tag = "v1.0.0",
+)
+
+new_go_repository(
+    name = "com_github_b_new",
+    importpath = "github.com/b/new",
+    tag = "v2",
+)
+
+go_repository(
+    name = "com_github_c_commit",
+    importpath = "github.com/c/commit",
+    commit = "cccc",
+)
+
+go_repository(
+    name = "com_github_d_unfetched",
+    importpath = "github.com/d/unfetched",
+    tag = "v3",
+)
+`
+	const want = `go_repository(
+    name = "com_github_a_tagged",
+    importpath = "github.com/a/tagged",
+    commit = "aaaa",
+)
+
+new_go_repository(
+    name = "com_github_b_new",
+    importpath = "github.com/b/new",
+    commit = "bbbb",
+)
+
+go_repository(
+    name = "com_github_c_commit",
+    importpath = "github.com/c/commit",
+    commit = "cccc",
+)
+
+go_repository(
+    name = "com_github_d_unfetched",
+    importpath = "github.com/d/unfetched",
+    tag = "v3",
+)
+`
+	f, err := bzl.Parse("WORKSPACE", []byte(workspace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := pinTags(f, external)
+	if err != nil {
+		t.Fatalf("pinTags failed with %v; want success", err)
+	}
+	wantChanges := []string{
+		"com_github_a_tagged: pinned tag v1.0.0 to commit aaaa",
+		"com_github_b_new: pinned tag v2 to commit bbbb",
+	}
+	if !reflect.DeepEqual(changes, wantChanges) {
+		t.Errorf("pinTags changes = %q; want %q", changes, wantChanges)
+	}
+	if got := string(bzl.Format(f)); got != want {
+		t.Errorf("WORKSPACE after pinTags:\n%s\nwant:\n%s", got, want)
+	}
+
+	f, err = bzl.Parse("WORKSPACE", []byte(`go_repository(name = "com_github_a_tagged", tag = "v0.9")`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pinTags(f, external); err == nil {
+		t.Errorf("pinTags with a stale fetch succeeded; want error")
+	}
+}
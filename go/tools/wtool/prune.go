@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	bzl "github.com/bazelbuild/buildifier/build"
+)
+
+// keep is the comment that marks a repository rule that must not be pruned,
+// as in gazelle.
+const keep = "# keep"
+
+// repoLabel matches labels in other repositories, such as "@name//pkg:target"
+// or "@name", and captures the repository name.
+var repoLabel = regexp.MustCompile(`@([A-Za-z0-9_][A-Za-z0-9_.-]*)(//|"|')`)
+
+// unusedRepositories returns the Go repository rules in f that are not
+// referred to by any BUILD, BUILD.bazel, .bzl or WORKSPACE file in the
+// workspace root, except for the rules marked with a keep comment.
+//
+// If external is not empty, it is the directory that holds the fetched
+// external repositories, and the files of each fetched repository that is
+// in use are searched as well, so that the dependencies of other
+// repositories are kept.
+//
+// The files are searched as text, so references in comments count too.
+func unusedRepositories(f *bzl.File, root, external string) ([]*bzl.Rule, error) {
+	used := make(map[string]bool)
+	if err := findRepoLabels(root, used); err != nil {
+		return nil, err
+	}
+	if external != "" {
+		searched := make(map[string]bool)
+		for more := true; more; {
+			more = false
+			for name := range used {
+				if searched[name] {
+					continue
+				}
+				searched[name] = true
+				dir, err := filepath.EvalSymlinks(filepath.Join(external, name))
+				if os.IsNotExist(err) {
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				if err := findRepoLabels(dir, used); err != nil {
+					return nil, err
+				}
+				more = true
+			}
+		}
+	}
+
+	var unused []*bzl.Rule
+	for _, r := range f.Rules("") {
+		if isRepositoryKind(r.Kind()) && !used[r.Name()] && !hasKeep(r) {
+			unused = append(unused, r)
+		}
+	}
+	return unused, nil
+}
+
+// findRepoLabels adds the names of the repositories that the build files
+// under dir refer to to used.
+func findRepoLabels(dir string, used map[string]bool) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		base := info.Name()
+		if info.IsDir() {
+			if path != dir && (strings.HasPrefix(base, ".") || strings.HasPrefix(base, "bazel-")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if base != "BUILD" && base != "BUILD.bazel" && base != "WORKSPACE" && filepath.Ext(base) != ".bzl" {
+			return nil
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, m := range repoLabel.FindAllSubmatch(b, -1) {
+			used[string(m[1])] = true
+		}
+		return nil
+	})
+}
+
+// hasKeep reports whether r, or the line with its name, has a keep comment.
+func hasKeep(r *bzl.Rule) bool {
+	comments := []*bzl.Comments{r.Call.Comment()}
+	if as := r.AttrDefn("name"); as != nil {
+		comments = append(comments, as.Comment(), as.X.Comment(), as.Y.Comment())
+	}
+	for _, c := range comments {
+		for _, l := range [][]bzl.Comment{c.Before, c.Suffix} {
+			for _, com := range l {
+				if strings.HasPrefix(com.Token, keep) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// pruneRepositories removes the rules rs from f.
+func pruneRepositories(f *bzl.File, rs []*bzl.Rule) {
+	remove := make(map[*bzl.CallExpr]bool)
+	for _, r := range rs {
+		remove[r.Call] = true
+	}
+	var stmt []bzl.Expr
+	for _, s := range f.Stmt {
+		if c, ok := s.(*bzl.CallExpr); ok && remove[c] {
+			continue
+		}
+		stmt = append(stmt, s)
+	}
+	f.Stmt = stmt
+}
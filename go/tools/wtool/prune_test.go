@@ -0,0 +1,150 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	bzl "github.com/bazelbuild/buildifier/build"
+)
+
+func TestUnusedRepositories(t *testing.T) {
+	dir, err := ioutil.TempDir(os.Getenv("TEST_TMPDIR"), "prune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "workspace")
+	external := filepath.Join(dir, "external")
+
+	const workspace = `workspace(name = "example")
+
+new_go_repository(
+    name = "com_example_build",
+    commit = "1111",
+    importpath = "example.com/build",
+)
+
+new_go_repository(
+    name = "com_example_bzl",
+    commit = "2222",
+    importpath = "example.com/bzl",
+)
+
+# Used by a script.
+# keep
+go_repository(
+    name = "com_example_kept",
+    commit = "3333",
+    importpath = "example.com/kept",
+)
+
+new_go_repository(
+    name = "com_example_kept_by_name",  # keep
+    commit = "4444",
+    importpath = "example.com/kept_by_name",
+)
+
+# Nothing uses this.
+new_go_repository(
+    name = "com_example_unused",
+    commit = "5555",
+    importpath = "example.com/unused",
+)
+
+new_go_repository(
+    name = "com_example_transitive",
+    commit = "6666",
+    importpath = "example.com/transitive",
+)
+
+new_go_repository(
+    name = "com_example_unused_dep",
+    commit = "7777",
+    importpath = "example.com/unused_dep",
+)
+
+bind(
+    name = "bound",
+    actual = "@com_example_bound//:go_default_library",
+)
+
+go_repository(
+    name = "com_example_bound",
+    commit = "8888",
+    importpath = "example.com/bound",
+)
+`
+	for path, content := range map[string]string{
+		"workspace/WORKSPACE":               workspace,
+		"workspace/cmd/BUILD":               `go_binary(name = "cmd", deps = ["@com_example_build//pkg:go_default_library"])`,
+		"workspace/tools/defs.bzl":          `DEPS = ["@com_example_bzl"]`,
+		"workspace/bazel-out/BUILD":         `deps = ["@com_example_unused//:x"]`,
+		"workspace/.hidden/BUILD":           `deps = ["@com_example_unused//:x"]`,
+		"workspace/notes.txt":               `@com_example_unused//:x`,
+		"external/com_example_build/BUILD":  `deps = ["@com_example_transitive//:go_default_library"]`,
+		"external/com_example_unused/BUILD": `deps = ["@com_example_unused_dep//:go_default_library"]`,
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := bzl.Parse("WORKSPACE", []byte(workspace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		external string
+		want     []string
+	}{
+		{"", []string{"com_example_unused", "com_example_transitive", "com_example_unused_dep"}},
+		{external, []string{"com_example_unused", "com_example_unused_dep"}},
+	} {
+		unused, err := unusedRepositories(f, root, c.external)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, r := range unused {
+			got = append(got, r.Name())
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("unusedRepositories(%q) = %q; want %q", c.external, got, c.want)
+		}
+	}
+
+	unused, err := unusedRepositories(f, root, external)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pruneRepositories(f, unused)
+	for _, name := range []string{"com_example_build", "com_example_kept", "com_example_kept_by_name", "com_example_transitive", "com_example_bound"} {
+		if findRepository(f, name, "") == nil {
+			t.Errorf("%s was pruned", name)
+		}
+	}
+	for _, name := range []string{"com_example_unused", "com_example_unused_dep"} {
+		if findRepository(f, name, "") != nil {
+			t.Errorf("%s was not pruned", name)
+		}
+	}
+}
+
+func TestPruneRequiresExternal(t *testing.T) {
+	// Without the fetched repositories, com_example_transitive in
+	// TestUnusedRepositories would be pruned although com_example_build
+	// depends on it.
+	defer func(p bool, e string) { *prune, *external = p, e }(*prune, *external)
+	*prune, *external = true, ""
+	if err := run(nil); err == nil || !strings.Contains(err.Error(), "-external") {
+		t.Errorf("run with -prune and no -external returned %v; want an error about -external", err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/tools/go/vcs"
+)
+
+// latestRevision resolves ref, or the latest revision if ref is empty, in
+// the repository at repo, and reports whether ref is a tag.
+//
+// Mercurial tags cannot be told apart from branches and bookmarks without a
+// clone, so any name that is not a changeset hash is reported as a tag.
+// Bazaar tags are given as "tag:NAME". Subversion has no tags apart from
+// directories, so refs are revision numbers.
+func latestRevision(v *vcs.Cmd, repo, ref string) (rev string, isTag bool, err error) {
+	var args []string
+	var parse func(string) (string, error)
+	switch v.Cmd {
+	case "git":
+		return lsRemote(repo, ref)
+	case "hg":
+		if ref == "" {
+			ref = "default"
+		}
+		args = []string{"hg", "identify", "--debug", "-r", ref, repo}
+		parse = parseHgIdentify
+	case "bzr":
+		args = []string{"bzr", "revno", repo}
+		if ref != "" {
+			args = []string{"bzr", "revno", "-r", ref, repo}
+		}
+		parse = parseBzrRevno
+	case "svn":
+		args = []string{"svn", "info", repo}
+		if ref != "" {
+			args = []string{"svn", "info", "-r", ref, repo}
+		}
+		parse = parseSvnInfo
+	default:
+		return "", false, fmt.Errorf("%s: unsupported version control system %q", repo, v.Cmd)
+	}
+
+	out, err := exec.Command(args[0], args[1:]...).Output()
+	if err != nil {
+		return "", false, fmt.Errorf("%s: %v", strings.Join(args, " "), err)
+	}
+	if *verbose {
+		log.Print(string(out))
+	}
+	rev, err = parse(string(out))
+	if err != nil {
+		return "", false, fmt.Errorf("%s: %v", strings.Join(args, " "), err)
+	}
+	switch v.Cmd {
+	case "hg":
+		isTag = ref != "default" && !strings.HasPrefix(rev, ref)
+	case "bzr":
+		isTag = strings.HasPrefix(ref, "tag:")
+	}
+	return rev, isTag, nil
+}
+
+// parseHgIdentify parses the output of "hg identify --debug", which starts
+// with the full changeset hash.
+func parseHgIdentify(out string) (string, error) {
+	fields := strings.Fields(out)
+	if len(fields) == 0 || !commitPattern.MatchString(strings.TrimSuffix(fields[0], "+")) {
+		return "", fmt.Errorf("unexpected output %q", out)
+	}
+	return fields[0], nil
+}
+
+// parseBzrRevno parses the output of "bzr revno", a revision number.
+func parseBzrRevno(out string) (string, error) {
+	revno := strings.TrimSpace(out)
+	if revno == "" || strings.Trim(revno, "0123456789.") != "" {
+		return "", fmt.Errorf("unexpected output %q", out)
+	}
+	return revno, nil
+}
+
+// parseSvnInfo returns the "Last Changed Rev" from the output of "svn info",
+// which is the latest revision that changed the given path.
+func parseSvnInfo(out string) (string, error) {
+	s := bufio.NewScanner(strings.NewReader(out))
+	for s.Scan() {
+		if rev := strings.TrimPrefix(s.Text(), "Last Changed Rev:"); rev != s.Text() {
+			return strings.TrimSpace(rev), nil
+		}
+	}
+	return "", fmt.Errorf("no revision in output %q", out)
+}
@@ -0,0 +1,68 @@
+package main
+
+import "testing"
+
+func TestParseRevision(t *testing.T) {
+	for _, c := range []struct {
+		desc  string
+		parse func(string) (string, error)
+		out   string
+		want  string
+	}{
+		{
+			desc:  "hg",
+			parse: parseHgIdentify,
+			out:   "7c3b1a8b2f0e4d6c9a5b3e1f0d2c4b6a8e0f1a2b\n",
+			want:  "7c3b1a8b2f0e4d6c9a5b3e1f0d2c4b6a8e0f1a2b",
+		},
+		{
+			desc:  "bzr",
+			parse: parseBzrRevno,
+			out:   "1042\n",
+			want:  "1042",
+		},
+		{
+			desc:  "bzr dotted",
+			parse: parseBzrRevno,
+			out:   "1040.1.3\n",
+			want:  "1040.1.3",
+		},
+		{
+			desc:  "svn",
+			parse: parseSvnInfo,
+			out: `Path: trunk
+URL: https://svn.example.com/repo/trunk
+Repository Root: https://svn.example.com/repo
+Revision: 4168
+Node Kind: directory
+Last Changed Author: someone
+Last Changed Rev: 4123
+Last Changed Date: 2017-03-01 10:00:00 +0000 (Wed, 01 Mar 2017)
+`,
+			want: "4123",
+		},
+	} {
+		got, err := c.parse(c.out)
+		if err != nil {
+			t.Errorf("%s: parse failed with %v; want success", c.desc, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: parse = %q; want %q", c.desc, got, c.want)
+		}
+	}
+
+	for _, c := range []struct {
+		desc  string
+		parse func(string) (string, error)
+		out   string
+	}{
+		{"hg", parseHgIdentify, "abort: unknown revision 'nope'!\n"},
+		{"bzr", parseBzrRevno, "bzr: ERROR: Not a branch\n"},
+		{"svn", parseSvnInfo, "Path: trunk\n"},
+	} {
+		if got, err := c.parse(c.out); err == nil {
+			t.Errorf("%s: parse(%q) = %q; want error", c.desc, c.out, got)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+
+	bzl "github.com/bazelbuild/buildifier/build"
+)
+
+// repositoryKinds are the rules that fetch Go repositories.
+var repositoryKinds = []string{"go_repository", "new_go_repository"}
+
+// repository describes a Go repository rule to add to or update in
+// WORKSPACE. Exactly one of commit and tag is set.
+type repository struct {
+	name, importpath string
+	commit, tag      string
+}
+
+// revision returns the attribute that pins the revision of r and its value.
+func (r *repository) revision() (key, value string) {
+	if r.tag != "" {
+		return "tag", r.tag
+	}
+	return "commit", r.commit
+}
+
+func isRepositoryKind(kind string) bool {
+	for _, k := range repositoryKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// findRepository returns the Go repository rule in f with the given name or
+// importpath, or nil if there is none.
+func findRepository(f *bzl.File, name, importpath string) *bzl.Rule {
+	for _, kind := range repositoryKinds {
+		for _, r := range f.Rules(kind) {
+			if r.Name() == name || (importpath != "" && r.AttrString("importpath") == importpath) {
+				return r
+			}
+		}
+	}
+	return nil
+}
+
+// setRevision pins r to a revision, where key is "commit" or "tag". An
+// existing commit or tag attribute is updated in place, so that its comments
+// are kept. It returns the previous revision in the form "key value", or ""
+// if r had none.
+func setRevision(r *bzl.Rule, key, value string) string {
+	other := "tag"
+	if key == "tag" {
+		other = "commit"
+	}
+	var old string
+	if v := r.AttrString(other); v != "" {
+		old = other + " " + v
+	}
+	if v := r.AttrString(key); v != "" {
+		old = key + " " + v
+	}
+
+	if as := r.AttrDefn(key); as != nil {
+		setString(as, value)
+		r.DelAttr(other)
+	} else if as := r.AttrDefn(other); as != nil {
+		as.X = &bzl.LiteralExpr{Token: key}
+		setString(as, value)
+	} else {
+		r.SetAttr(key, &bzl.StringExpr{Value: value})
+	}
+	return old
+}
+
+func setString(as *bzl.BinaryExpr, value string) {
+	if s, ok := as.Y.(*bzl.StringExpr); ok {
+		s.Value = value
+		s.Token = ""
+		return
+	}
+	as.Y = &bzl.StringExpr{Value: value}
+}
+
+// updateRepository adds a new_go_repository rule for repo to f, or, if f
+// already has a rule for it, updates the rule's revision while leaving its
+// other attributes and comments alone. It returns a description of what it
+// did.
+func updateRepository(f *bzl.File, repo *repository) string {
+	key, value := repo.revision()
+	if r := findRepository(f, repo.name, repo.importpath); r != nil {
+		old := setRevision(r, key, value)
+		switch old {
+		case key + " " + value:
+			return fmt.Sprintf("%s: already at %s %s", r.Name(), key, value)
+		case "":
+			return fmt.Sprintf("%s: set %s %s", r.Name(), key, value)
+		default:
+			return fmt.Sprintf("%s: updated %s to %s %s", r.Name(), old, key, value)
+		}
+	}
+
+	f.Stmt = append(f.Stmt, &bzl.CallExpr{
+		X: &bzl.LiteralExpr{Token: "new_go_repository"},
+		List: []bzl.Expr{
+			attr("name", repo.name),
+			attr(key, value),
+			attr("importpath", repo.importpath),
+		},
+	})
+	return fmt.Sprintf("%s: added new_go_repository at %s %s", repo.name, key, value)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	bzl "github.com/bazelbuild/buildifier/build"
+)
+
+func TestUpdateRepository(t *testing.T) {
+	const workspace = `# Dependencies.
+
+new_go_repository(
+    name = "com_github_golang_glog",
+    commit = "0000",  # old glog
+    importpath = "github.com/golang/glog",
+)
+
+# Renamed, but still the same import path.
+go_repository(
+    name = "glog_fork",
+    build_file_name = "BUILD.bazel",
+    importpath = "github.com/example/fork",
+    tag = "v1.0",
+)
+
+new_go_repository(
+    name = "org_golang_x_net",
+    commit = "1111",
+    importpath = "golang.org/x/net",
+)
+`
+	const want = `# Dependencies.
+
+new_go_repository(
+    name = "com_github_golang_glog",
+    commit = "2222",  # old glog
+    importpath = "github.com/golang/glog",
+)
+
+# Renamed, but still the same import path.
+go_repository(
+    name = "glog_fork",
+    build_file_name = "BUILD.bazel",
+    importpath = "github.com/example/fork",
+    commit = "3333",
+)
+
+new_go_repository(
+    name = "org_golang_x_net",
+    commit = "1111",
+    importpath = "golang.org/x/net",
+)
+
+new_go_repository(
+    name = "com_github_pkg_errors",
+    commit = "4444",
+    importpath = "github.com/pkg/errors",
+)
+`
+	f, err := bzl.Parse("WORKSPACE", []byte(workspace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changes []string
+	for _, repo := range []*repository{
+		{name: "com_github_golang_glog", importpath: "github.com/golang/glog", commit: "2222"},
+		{name: "com_github_example_fork", importpath: "github.com/example/fork", commit: "3333"},
+		{name: "org_golang_x_net", importpath: "golang.org/x/net", commit: "1111"},
+		{name: "com_github_pkg_errors", importpath: "github.com/pkg/errors", commit: "4444"},
+	} {
+		changes = append(changes, updateRepository(f, repo))
+	}
+	wantChanges := []string{
+		"com_github_golang_glog: updated commit 0000 to commit 2222",
+		"glog_fork: updated tag v1.0 to commit 3333",
+		"org_golang_x_net: already at commit 1111",
+		"com_github_pkg_errors: added new_go_repository at commit 4444",
+	}
+	if !reflect.DeepEqual(changes, wantChanges) {
+		t.Errorf("updateRepository changes = %q; want %q", changes, wantChanges)
+	}
+	if got := string(bzl.Format(f)); got != want {
+		t.Errorf("WORKSPACE after updateRepository:\n%s\nwant:\n%s", got, want)
+	}
+}